@@ -0,0 +1,272 @@
+package cloudup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/automattic/go/jaguar"
+)
+
+// DefaultPerPage is the page size used when listing without ListOptions
+const DefaultPerPage = 50
+
+type User struct {
+	Id        string `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarUrl string `json:"avatar_url"`
+	Url       string `json:"url"`
+}
+
+// ListOptions selects a page of results, pages start at 1
+type ListOptions struct {
+	Page    int
+	PerPage int
+}
+
+// StreamList is a page of streams, NextPage is 0 on the last page
+type StreamList struct {
+	Streams  []Stream
+	NextPage int
+}
+
+// ItemList is a page of items, NextPage is 0 on the last page
+type ItemList struct {
+	Items    []Item
+	NextPage int
+}
+
+// User returns the authenticated user
+func (client Client) User() (User, error) {
+	return client.UserContext(context.Background())
+}
+
+func (client Client) UserContext(ctx context.Context) (user User, err error) {
+	j := client.newRequestContext(ctx)
+	err = client.send(j.Get(client.apiURL("/1/user")), &user)
+	return user, err
+}
+
+// ListStreams returns a page of the user's streams
+func (client Client) ListStreams(opts ListOptions) (StreamList, error) {
+	return client.ListStreamsContext(context.Background(), opts)
+}
+
+func (client Client) ListStreamsContext(ctx context.Context, opts ListOptions) (list StreamList, err error) {
+	j := client.newRequestContext(ctx)
+	opts.params(j.Params)
+	resp, err := client.sendResponse(j.Get(client.apiURL("/1/streams")), &list.Streams)
+	list.NextPage = nextPage(resp)
+	return list, err
+}
+
+// AllStreams returns every stream of the user, following pagination
+func (client Client) AllStreams() ([]Stream, error) {
+	return client.AllStreamsContext(context.Background())
+}
+
+func (client Client) AllStreamsContext(ctx context.Context) (streams []Stream, err error) {
+	opts := ListOptions{Page: 1, PerPage: DefaultPerPage}
+	for opts.Page != 0 {
+		list, err := client.ListStreamsContext(ctx, opts)
+		if err != nil {
+			return streams, err
+		}
+		streams = append(streams, list.Streams...)
+		opts.Page = list.NextPage
+	}
+	return streams, nil
+}
+
+func (client Client) GetStream(streamId string) (Stream, error) {
+	return client.GetStreamContext(context.Background(), streamId)
+}
+
+func (client Client) GetStreamContext(ctx context.Context, streamId string) (cs Stream, err error) {
+	j := client.newRequestContext(ctx)
+	err = client.send(j.Get(client.apiURL("/1/streams/"+url.PathEscape(streamId))), &cs)
+	return cs, err
+}
+
+// UpdateStream changes the title of a stream
+func (client Client) UpdateStream(streamId, title string) (Stream, error) {
+	return client.UpdateStreamContext(context.Background(), streamId, title)
+}
+
+func (client Client) UpdateStreamContext(ctx context.Context, streamId, title string) (cs Stream, err error) {
+	j := client.newRequestContext(ctx)
+	j.JsonData = map[string]interface{}{
+		"title": title,
+	}
+	err = client.send(j.Patch(client.apiURL("/1/streams/"+url.PathEscape(streamId))), &cs)
+	return cs, err
+}
+
+// ReorderItems sets the order of the items in a stream, itemIds must list
+// every item of the stream
+func (client Client) ReorderItems(streamId string, itemIds []string) (Stream, error) {
+	return client.ReorderItemsContext(context.Background(), streamId, itemIds)
+}
+
+func (client Client) ReorderItemsContext(ctx context.Context, streamId string, itemIds []string) (cs Stream, err error) {
+	j := client.newRequestContext(ctx)
+	j.JsonData = map[string]interface{}{
+		"items": itemIds,
+	}
+	err = client.send(j.Patch(client.apiURL("/1/streams/"+url.PathEscape(streamId))), &cs)
+	return cs, err
+}
+
+// DeleteStream deletes a stream and its items
+func (client Client) DeleteStream(streamId string) error {
+	return client.DeleteStreamContext(context.Background(), streamId)
+}
+
+func (client Client) DeleteStreamContext(ctx context.Context, streamId string) error {
+	j := client.newRequestContext(ctx)
+	return client.send(j.Delete(client.apiURL("/1/streams/"+url.PathEscape(streamId))), nil)
+}
+
+// ListItems returns a page of the items in a stream, in stream order
+func (client Client) ListItems(streamId string, opts ListOptions) (ItemList, error) {
+	return client.ListItemsContext(context.Background(), streamId, opts)
+}
+
+func (client Client) ListItemsContext(ctx context.Context, streamId string, opts ListOptions) (list ItemList, err error) {
+	j := client.newRequestContext(ctx)
+	opts.params(j.Params)
+	j.Params.Set("stream_id", streamId)
+	resp, err := client.sendResponse(j.Get(client.apiURL("/1/items")), &list.Items)
+	list.NextPage = nextPage(resp)
+	return list, err
+}
+
+// AllItems returns every item in a stream, following pagination
+func (client Client) AllItems(streamId string) ([]Item, error) {
+	return client.AllItemsContext(context.Background(), streamId)
+}
+
+func (client Client) AllItemsContext(ctx context.Context, streamId string) (items []Item, err error) {
+	opts := ListOptions{Page: 1, PerPage: DefaultPerPage}
+	for opts.Page != 0 {
+		list, err := client.ListItemsContext(ctx, streamId, opts)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, list.Items...)
+		opts.Page = list.NextPage
+	}
+	return items, nil
+}
+
+func (client Client) GetItem(itemId string) (Item, error) {
+	return client.GetItemContext(context.Background(), itemId)
+}
+
+func (client Client) GetItemContext(ctx context.Context, itemId string) (ci Item, err error) {
+	j := client.newRequestContext(ctx)
+	err = client.send(j.Get(client.apiURL("/1/items/"+url.PathEscape(itemId))), &ci)
+	return ci, err
+}
+
+// UpdateItem changes the title of an item
+func (client Client) UpdateItem(itemId, title string) (Item, error) {
+	return client.UpdateItemContext(context.Background(), itemId, title)
+}
+
+func (client Client) UpdateItemContext(ctx context.Context, itemId, title string) (ci Item, err error) {
+	j := client.newRequestContext(ctx)
+	j.JsonData = map[string]interface{}{
+		"title": title,
+	}
+	err = client.send(j.Patch(client.apiURL("/1/items/"+url.PathEscape(itemId))), &ci)
+	return ci, err
+}
+
+func (client Client) DeleteItem(itemId string) error {
+	return client.DeleteItemContext(context.Background(), itemId)
+}
+
+func (client Client) DeleteItemContext(ctx context.Context, itemId string) error {
+	j := client.newRequestContext(ctx)
+	return client.send(j.Delete(client.apiURL("/1/items/"+url.PathEscape(itemId))), nil)
+}
+
+func (opts ListOptions) params(p url.Values) {
+	if opts.Page > 0 {
+		p.Set("page", strconv.Itoa(opts.Page))
+	}
+	if opts.PerPage > 0 {
+		p.Set("per_page", strconv.Itoa(opts.PerPage))
+	}
+}
+
+// send executes the request and decodes the JSON response into v
+func (client Client) send(j *jaguar.Jaguar, v interface{}) error {
+	_, err := client.sendResponse(j, v)
+	return err
+}
+
+func (client Client) sendResponse(j *jaguar.Jaguar, v interface{}) (resp jaguar.Response, err error) {
+	ctx := j.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// API requests with JsonData send it as JSON
+	j.Json = j.JsonData != nil
+	requestUrl := redactURL(j.RequestUrl)
+	resp, err = j.Send()
+	if err != nil {
+		client.log(ctx, slog.LevelWarn, "cloudup request failed", "method", j.RequestMethod, "url", requestUrl, "error", err)
+		return resp, fmt.Errorf("cloudup: %s %s: %w", j.RequestMethod, requestUrl, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := newAPIError(j, resp)
+		client.log(ctx, slog.LevelWarn, "cloudup request failed", "method", j.RequestMethod, "url", requestUrl,
+			"status", resp.StatusCode, "code", apiErr.Code, "request_id", apiErr.RequestId)
+		return resp, apiErr
+	}
+	client.log(ctx, slog.LevelDebug, "cloudup request", "method", j.RequestMethod, "url", requestUrl, "status", resp.StatusCode)
+
+	if v == nil || len(resp.Bytes) == 0 {
+		return resp, nil
+	}
+	if err = json.Unmarshal(resp.Bytes, v); err != nil {
+		return resp, fmt.Errorf("cloudup: %s %s: decode response: %w", j.RequestMethod, requestUrl, err)
+	}
+	return resp, nil
+}
+
+// log writes to the client logger, if there is one
+func (client Client) log(ctx context.Context, level slog.Level, msg string, args ...interface{}) {
+	if client.Logger != nil {
+		client.Logger.Log(ctx, level, msg, args...)
+	}
+}
+
+var linkNext = regexp.MustCompile(`<([^>]*)>\s*;\s*rel="?next"?`)
+
+// nextPage reads the page number of the rel="next" Link header
+func nextPage(resp jaguar.Response) int {
+	for _, link := range resp.Header.Values("Link") {
+		m := linkNext.FindStringSubmatch(link)
+		if m == nil {
+			continue
+		}
+		u, err := url.Parse(m[1])
+		if err != nil {
+			return 0
+		}
+		page, _ := strconv.Atoi(u.Query().Get("page"))
+		return page
+	}
+	return 0
+}
@@ -0,0 +1,287 @@
+package cloudup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultBulkWorkers is the number of concurrent uploads when
+// BulkOptions.Workers is not set
+const DefaultBulkWorkers = 4
+
+var ErrManifestStream = errors.New("cloudup: manifest belongs to another stream")
+
+// BulkOptions control BulkUpload
+type BulkOptions struct {
+	// Include and Exclude are glob patterns matched against the path of
+	// each file relative to the directory and against its base name. With
+	// no Include patterns every file is included.
+	Include []string
+	Exclude []string
+
+	// Workers is the maximum number of uploads in flight
+	Workers int
+
+	// Manifest is the path of a file recording completed uploads, files
+	// listed in it with the same size and modification time are skipped
+	// so an interrupted run can be resumed
+	Manifest string
+
+	// Upload options used for every file, Title and Size are ignored
+	Upload UploadOptions
+
+	// OnFile, when set, is called after each file is uploaded or fails,
+	// one call at a time
+	OnFile func(path string, item Item, err error)
+}
+
+// BulkResult lists what BulkUpload did, paths are relative to the
+// directory
+type BulkResult struct {
+	Uploaded []string
+	Skipped  []string
+	Failed   map[string]error
+}
+
+// Manifest records the files uploaded to a stream
+type Manifest struct {
+	StreamId string                   `json:"stream_id"`
+	Files    map[string]ManifestEntry `json:"files"`
+}
+
+type ManifestEntry struct {
+	ItemId  string    `json:"item_id"`
+	Url     string    `json:"url"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+
+	// Checksum is the hex MD5 of the file, recorded by Sync
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// LoadManifest reads a manifest, a missing file gives an empty manifest
+func LoadManifest(path string) (*Manifest, error) {
+	m := &Manifest{Files: map[string]ManifestEntry{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("cloudup: manifest %s: %w", path, err)
+	}
+	if m.Files == nil {
+		m.Files = map[string]ManifestEntry{}
+	}
+	return m, nil
+}
+
+// Save writes the manifest, replacing the file atomically
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// BulkUpload uploads the files under dir to the stream with a bounded
+// number of concurrent uploads, items are named by the file path relative
+// to dir as Sync expects. Failed files are reported in the result
+// and the error, the other files are still uploaded.
+func (client Client) BulkUpload(ctx context.Context, streamId, dir string, opts *BulkOptions) (BulkResult, error) {
+	result := BulkResult{Failed: map[string]error{}}
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+
+	manifest := &Manifest{Files: map[string]ManifestEntry{}}
+	if opts.Manifest != "" {
+		var err error
+		if manifest, err = LoadManifest(opts.Manifest); err != nil {
+			return result, err
+		}
+		if manifest.StreamId != "" && manifest.StreamId != streamId {
+			return result, ErrManifestStream
+		}
+		manifest.StreamId = streamId
+	}
+
+	type file struct {
+		path, rel string
+		info      os.FileInfo
+	}
+	var files []file
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if opts.Manifest != "" && (sameFile(path, opts.Manifest) || sameFile(path, opts.Manifest+".tmp")) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !opts.match(rel) {
+			return nil
+		}
+		if e, ok := manifest.Files[rel]; ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) {
+			result.Skipped = append(result.Skipped, rel)
+			return nil
+		}
+		files = append(files, file{path, rel, info})
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultBulkWorkers
+	}
+
+	// a manifest which can't be saved stops the uploads, a later run
+	// would upload again whatever isn't recorded
+	var mu sync.Mutex
+	var saveErr error
+	saveFailed := make(chan struct{})
+	jobs := make(chan file)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				mu.Lock()
+				stopped := saveErr != nil
+				mu.Unlock()
+				if stopped {
+					continue
+				}
+
+				upload := opts.Upload
+				upload.Title, upload.Size = "", f.info.Size()
+				item, err := client.uploadAs(ctx, streamId, f.path, f.rel, &upload)
+
+				mu.Lock()
+				if err != nil {
+					result.Failed[f.rel] = err
+				} else {
+					result.Uploaded = append(result.Uploaded, f.rel)
+					manifest.Files[f.rel] = ManifestEntry{
+						ItemId:  item.Id,
+						Url:     item.Url,
+						Size:    f.info.Size(),
+						ModTime: f.info.ModTime(),
+					}
+					if opts.Manifest != "" && saveErr == nil {
+						if saveErr = manifest.Save(opts.Manifest); saveErr != nil {
+							close(saveFailed)
+						}
+					}
+				}
+				if opts.OnFile != nil {
+					opts.OnFile(f.rel, item, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for _, f := range files {
+		select {
+		case jobs <- f:
+		case <-saveFailed:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	sort.Strings(result.Uploaded)
+
+	if saveErr != nil {
+		return result, fmt.Errorf("cloudup: save manifest: %w", saveErr)
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if len(result.Failed) > 0 {
+		for _, f := range files {
+			if err, ok := result.Failed[f.rel]; ok {
+				return result, fmt.Errorf("cloudup: %d of %d uploads failed, %s: %w", len(result.Failed), len(files), f.rel, err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// match applies the include and exclude patterns to a relative path
+// uploadAs uploads the file at path as an item named name, BulkUpload and
+// Sync name items by their path relative to the directory
+func (client Client) uploadAs(ctx context.Context, streamId, path, name string, opts *UploadOptions) (Item, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Item{}, err
+	}
+	defer file.Close()
+	return client.UploadReader(ctx, streamId, name, file, opts)
+}
+
+func (opts *BulkOptions) match(rel string) bool {
+	return matchPatterns(opts.Include, opts.Exclude, rel)
+}
+
+// matchPatterns reports whether a relative path matches an include
+// pattern, or there are none, and no exclude pattern
+func matchPatterns(include, exclude []string, rel string) bool {
+	matches := func(patterns []string) bool {
+		for _, p := range patterns {
+			if ok, _ := filepath.Match(p, rel); ok {
+				return true
+			}
+			if ok, _ := filepath.Match(p, filepath.Base(rel)); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if len(include) > 0 && !matches(include) {
+		return false
+	}
+	return !matches(exclude)
+}
+
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
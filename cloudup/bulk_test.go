@@ -0,0 +1,133 @@
+package cloudup_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/automattic/go/cloudup"
+	"github.com/automattic/go/cloudup/clouduptest"
+)
+
+// This tests BulkUpload filters files, records failures and resumes from
+// the manifest without duplicates
+func TestBulkUpload(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	dir := filepath.Dir(tempFile(t, "a.png", "a"))
+	for _, name := range []string{"b.png", "c.jpg", "skip.txt"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "d.png"), []byte("d"), 0644)
+	manifest := filepath.Join(dir, "manifest.json")
+
+	stream, _ := client.CreateStream("Bulk")
+
+	// one file fails, with retries disabled
+	server.Fail("POST", "/1/items", http.StatusForbidden, 1)
+	var calls int32
+	opts := &cloudup.BulkOptions{
+		Include:  []string{"*.png", "*.jpg"},
+		Workers:  2,
+		Manifest: manifest,
+		Upload:   cloudup.UploadOptions{Retries: -1},
+		OnFile: func(path string, item cloudup.Item, err error) {
+			atomic.AddInt32(&calls, 1)
+		},
+	}
+	result, err := client.BulkUpload(context.Background(), stream.Id, dir, opts)
+	if err == nil || len(result.Failed) != 1 || len(result.Uploaded) != 3 || calls != 4 {
+		t.Fatalf("Unexpected result: %+v %v", result, err)
+	}
+
+	result, err = client.BulkUpload(context.Background(), stream.Id, dir, opts)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(result.Uploaded) != 1 || len(result.Skipped) != 3 {
+		t.Errorf("Expected only the failed file to be uploaded: %+v", result)
+	}
+	if items := server.Items(stream.Id); len(items) != 4 {
+		t.Errorf("Expected 4 items, got %d", len(items))
+	}
+
+	m, _ := cloudup.LoadManifest(manifest)
+	if m.StreamId != stream.Id || len(m.Files) != 4 || m.Files["sub/d.png"].ItemId == "" {
+		t.Errorf("Unexpected manifest: %+v", m)
+	}
+
+	other, _ := client.CreateStream("Other")
+	if _, err = client.BulkUpload(context.Background(), other.Id, dir, opts); err != cloudup.ErrManifestStream {
+		t.Errorf("Expected manifest stream error, got %v", err)
+	}
+}
+
+// This tests uploads stop when the manifest can't be saved
+func TestBulkUploadManifestError(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	stream, _ := client.CreateStream("Photos")
+
+	dir := filepath.Dir(tempFile(t, "a.png", "a"))
+	for _, name := range []string{"b.png", "c.png", "d.png"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+	manifest := filepath.Join(dir, "missing", "manifest.json")
+
+	result, err := client.BulkUpload(context.Background(), stream.Id, dir, &cloudup.BulkOptions{Manifest: manifest, Workers: 1})
+	if err == nil || !strings.Contains(err.Error(), "save manifest") {
+		t.Errorf("Expected manifest error, got %v", err)
+	}
+	if len(result.Uploaded) != 1 || len(server.Items(stream.Id)) != 1 {
+		t.Errorf("Expected uploads to stop, got %v", result.Uploaded)
+	}
+
+	_, err = client.Sync(context.Background(), stream.Id, dir, &cloudup.SyncOptions{Manifest: manifest, Workers: 1})
+	if err == nil || !strings.Contains(err.Error(), "save manifest") {
+		t.Errorf("Expected manifest error, got %v", err)
+	}
+	if n := len(server.Items(stream.Id)); n != 1 {
+		t.Errorf("Expected sync not to start, got %d items", n)
+	}
+}
+
+// This tests BulkUpload names items by their relative path, so a later
+// Sync without a manifest finds them
+func TestBulkUploadThenSync(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	ctx := context.Background()
+
+	dir := filepath.Dir(tempFile(t, "x.png", "root"))
+	for _, sub := range []string{"a", "b"} {
+		os.Mkdir(filepath.Join(dir, sub), 0755)
+		ioutil.WriteFile(filepath.Join(dir, sub, "x.png"), []byte(sub), 0644)
+	}
+
+	stream, _ := client.CreateStream("Tree")
+	if _, err := client.BulkUpload(ctx, stream.Id, dir, nil); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	var names []string
+	for _, item := range server.Items(stream.Id) {
+		names = append(names, item.Filename)
+	}
+	if len(names) != 3 || !strings.Contains(strings.Join(names, ","), "a/x.png") {
+		t.Errorf("Unexpected item names: %v", names)
+	}
+
+	result, err := client.Sync(ctx, stream.Id, dir, &cloudup.SyncOptions{DryRun: true})
+	if err != nil || len(result.Changes) != 0 {
+		t.Errorf("Expected nothing to sync, got %s %v", syncPlan(result), err)
+	}
+}
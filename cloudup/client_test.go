@@ -0,0 +1,81 @@
+package cloudup_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/automattic/go/cloudup"
+	"github.com/automattic/go/cloudup/clouduptest"
+	"github.com/automattic/go/jaguar"
+)
+
+type countingTransport struct {
+	count int
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.count++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// This tests the client options are applied to requests
+func TestNewClient(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+
+	tr := &countingTransport{}
+	var configured int
+	client := cloudup.NewClient(
+		cloudup.WithBasicToken(clouduptest.Token),
+		cloudup.WithBaseURL(server.URL+"/"),
+		cloudup.WithHTTPClient(&http.Client{Transport: tr}),
+		cloudup.WithJaguar(func(j *jaguar.Jaguar) {
+			configured++
+		}),
+	)
+
+	stream, err := client.CreateStreamContext(context.Background(), "Options")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if _, err = client.GetStreamContext(context.Background(), stream.Id); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if tr.count != 2 || configured != 2 {
+		t.Errorf("Expected 2 requests through transport and configure, got %d and %d", tr.count, configured)
+	}
+}
+
+// This tests the user agent, timeout and context cancellation
+func TestClientTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/1/user" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		fmt.Fprintf(w, `{"id":"s1","title":%q}`, r.Header.Get("User-Agent"))
+	}))
+	defer ts.Close()
+
+	client := cloudup.NewClient(cloudup.WithBaseURL(ts.URL), cloudup.WithUserAgent("screenshots/1.0"))
+	stream, err := client.GetStream("s1")
+	if err != nil || stream.Title != "screenshots/1.0" {
+		t.Errorf("Expected user agent to be sent: %+v %v", stream, err)
+	}
+
+	client = cloudup.NewClient(cloudup.WithBaseURL(ts.URL), cloudup.WithTimeout(10*time.Millisecond))
+	if _, err = client.User(); err == nil {
+		t.Errorf("Expected timeout error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client = cloudup.NewClient(cloudup.WithBaseURL(ts.URL))
+	if _, err = client.UserContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancelled error, got %v", err)
+	}
+}
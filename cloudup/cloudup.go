@@ -1,149 +1,304 @@
 package cloudup
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
-	"log"
+	"context"
+	"io"
+	"log/slog"
 	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/automattic/go/jaguar"
 )
 
-// TODO: use go http lib to make more generic
+// DefaultBaseURL is the Cloudup API used when Client.BaseURL is empty
+const DefaultBaseURL = "https://api.cloudup.com"
 
-var baseURL = "https://api.cloudup.com"
+// DefaultUserAgent is sent when Client.UserAgent is empty
+const DefaultUserAgent = "automattic-go-cloudup"
 
 type Client struct {
 	BasicToken string
 	OAuthToken string
+
+	// BaseURL of the API, defaults to DefaultBaseURL
+	BaseURL string
+
+	// Logger, when set, receives failed requests at warn level and the
+	// others at debug level
+	Logger *slog.Logger
+
+	// UserAgent sent with requests, defaults to DefaultUserAgent
+	UserAgent string
+
+	// Timeout limits each request, including S3 uploads, zero means no
+	// timeout other than the context
+	Timeout time.Duration
+
+	// Transport, when set, sends the requests
+	Transport http.RoundTripper
+
+	// Configure, when set, is called with each request before it is sent,
+	// to set jaguar options such as rate limits, hooks or debugging
+	Configure func(j *jaguar.Jaguar)
+}
+
+// Option configures a Client created with NewClient
+type Option func(*Client)
+
+// NewClient creates a client configured with options
+func NewClient(opts ...Option) Client {
+	var client Client
+	for _, opt := range opts {
+		opt(&client)
+	}
+	return client
+}
+
+// WithBasicToken authenticates with a base64 encoded username:password
+func WithBasicToken(token string) Option {
+	return func(c *Client) { c.BasicToken = token }
+}
+
+// WithOAuthToken authenticates with an OAuth bearer token
+func WithOAuthToken(token string) Option {
+	return func(c *Client) { c.OAuthToken = token }
+}
+
+// WithBaseURL points the client at another API endpoint, such as staging
+// or a clouduptest server
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) { c.BaseURL = strings.TrimRight(baseURL, "/") }
+}
+
+// WithHTTPClient sends requests with the transport and timeout of hc
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.Transport = hc.Transport
+		c.Timeout = hc.Timeout
+	}
+}
+
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) { c.UserAgent = userAgent }
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.Timeout = timeout }
+}
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) { c.Logger = logger }
+}
+
+// WithJaguar calls configure with each request before it is sent
+func WithJaguar(configure func(j *jaguar.Jaguar)) Option {
+	return func(c *Client) { c.Configure = configure }
 }
 
 type Item struct {
-	Id          string `json:"id"`
-	Title       string `json:"title"`
-	Url         string `json:"url"`
-	DirectUrl   string `json:"direct_url"`
-	Filename    string `json:"filename"`
-	S3Key       string `json:"s3_key"`
-	S3Policy    string `json:"s3_policy"`
-	S3Signature string `json:"s3_signature"`
-	S3Url       string `json:"s3_url"`
-	S3AccessKey string `json:"s3_access_key"`
+	Id          string    `json:"id"`
+	StreamId    string    `json:"stream_id"`
+	Title       string    `json:"title"`
+	Url         string    `json:"url"`
+	DirectUrl   string    `json:"direct_url"`
+	Filename    string    `json:"filename"`
+	Mime        string    `json:"mime"`
+	Size        int64     `json:"size"`
+	Complete    bool      `json:"complete"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	S3Key       string    `json:"s3_key"`
+	S3Policy    string    `json:"s3_policy"`
+	S3Signature string    `json:"s3_signature"`
+	S3Url       string    `json:"s3_url"`
+	S3AccessKey string    `json:"s3_access_key"`
+
+	// S3Fields are the form fields of a SigV4 POST policy, sent instead of
+	// S3Policy, S3Signature and S3AccessKey when set
+	S3Fields map[string]string `json:"s3_fields"`
+
+	// S3PutUrl is a presigned url the data is PUT to, instead of posting
+	// a form to S3Url
+	S3PutUrl string `json:"s3_put_url"`
 }
 
 type Stream struct {
-	Id    string   `json:"id"`
-	Title string   `json:"title"`
-	Items []string `json:"items"`
-	Url   string   `json:"url"`
+	Id        string    `json:"id"`
+	Title     string    `json:"title"`
+	Items     []string  `json:"items"`
+	Url       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
-func apiURL(path string) string {
-	return baseURL + path
+func (client Client) apiURL(path string) string {
+	if client.BaseURL != "" {
+		return client.BaseURL + path
+	}
+	return DefaultBaseURL + path
 }
 
-func (client Client) newRequest() jaguar.Jaguar {
+// newJaguar creates a request with the client transport settings and no
+// credentials, as used for S3
+func (client Client) newJaguar(ctx context.Context) jaguar.Jaguar {
 	j := jaguar.New()
+	j.WithContext(ctx)
+	j.WithTimeout(client.Timeout)
+	j.WithTransport(client.Transport)
+
+	userAgent := client.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	j.Header.Set("User-Agent", userAgent)
+
+	if client.Configure != nil {
+		client.Configure(&j)
+	}
+	return j
+}
+
+// newRequestContext creates an authenticated API request
+func (client Client) newRequestContext(ctx context.Context) jaguar.Jaguar {
+	j := client.newJaguar(ctx)
 	switch {
 	case client.OAuthToken != "":
-		j.Header.Add("Authorization", "Bearer "+client.OAuthToken)
+		j.WithAuth(jaguar.BearerAuth(client.OAuthToken))
 	case client.BasicToken != "":
-		j.Header.Add("Authorization", "Basic "+client.BasicToken)
+		j.WithAuth(jaguar.BasicTokenAuth(client.BasicToken))
 	}
 	return j
 }
 
-func (client Client) CreateItem(streamId, filename, title string) (ci Item, err error) {
-	url := apiURL("/1/items")
+func (client Client) CreateItem(streamId, filename, title string) (Item, error) {
+	return client.CreateItemContext(context.Background(), streamId, filename, title)
+}
+
+func (client Client) CreateItemContext(ctx context.Context, streamId, filename, title string) (Item, error) {
 	ext := filepath.Ext(filename)
 	mimetype := mime.TypeByExtension(ext)
+	return client.createItem(ctx, streamId, filename, title, mimetype)
+}
+
+func (client Client) createItem(ctx context.Context, streamId, filename, title, mimetype string) (ci Item, err error) {
+	url := client.apiURL("/1/items")
 
-	j := client.newRequest()
+	j := client.newRequestContext(ctx)
 	j.Params.Add("filename", filename)
 	j.Params.Add("title", title)
 	j.Params.Add("stream_id", streamId)
 	j.Params.Add("mime", mimetype)
 
-	resp, err := j.Post(url).Send()
-	if err != nil {
-		return ci, err
-	}
-
-	err = json.Unmarshal(resp.Bytes, &ci)
-	if err != nil {
-		log.Printf("Cloudup response: %v", resp.String())
-	}
-
+	err = client.send(j.Post(url), &ci)
 	ci.Filename = filename
 	return ci, err
 }
 
 func (client Client) CompleteItem(ci Item) error {
-	url := apiURL("/1/items/" + ci.Id)
+	return client.CompleteItemContext(context.Background(), ci)
+}
 
-	j := client.newRequest()
+func (client Client) CompleteItemContext(ctx context.Context, ci Item) error {
+	_, err := client.completeItem(ctx, ci)
+	return err
+}
+
+func (client Client) completeItem(ctx context.Context, ci Item) (item Item, err error) {
+	url := client.apiURL("/1/items/" + ci.Id)
+
+	j := client.newRequestContext(ctx)
 	j.JsonData = map[string]interface{}{
 		"complete": true,
 	}
 
-	_, err := j.Patch(url).JsonRequest()
-	if err != nil {
-		return err
-	}
+	err = client.send(j.Patch(url), &item)
+	return item, err
+}
 
-	return err
+func (client Client) CreateStream(title string) (Stream, error) {
+	return client.CreateStreamContext(context.Background(), title)
 }
 
-func (client Client) CreateStream(title string) (cs Stream, err error) {
-	url := apiURL("/1/streams")
+func (client Client) CreateStreamContext(ctx context.Context, title string) (cs Stream, err error) {
+	url := client.apiURL("/1/streams")
 
-	j := client.newRequest()
+	j := client.newRequestContext(ctx)
 	j.Params.Add("title", title)
 
-	resp, err := j.Post(url).Send()
-	if err != nil {
-		return cs, err
-	}
-
-	err = json.Unmarshal(resp.Bytes, &cs)
-	if err != nil {
-		fmt.Println("Error unmarshaling request: ", resp.String())
-	}
+	err = client.send(j.Post(url), &cs)
 	return cs, err
 }
 
+// UploadToS3 uploads the file at ci.Filename for the item
 func (client Client) UploadToS3(ci Item) error {
+	return client.UploadToS3Context(context.Background(), ci)
+}
 
-	fileinfo, err := os.Stat(ci.Filename)
+func (client Client) UploadToS3Context(ctx context.Context, ci Item) error {
+	file, err := os.Open(ci.Filename)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	ext := filepath.Ext(ci.Filename)
-
-	j := jaguar.New()
-	j.Url(ci.S3Url)
-	j.Params.Add("key", ci.S3Key)
-	j.Params.Add("AWSAccessKeyId", ci.S3AccessKey)
-	j.Params.Add("acl", "public-read")
-	j.Params.Add("policy", ci.S3Policy)
-	j.Params.Add("signature", ci.S3Signature)
-	j.Params.Add("Content-Type", mime.TypeByExtension(ext))
-	j.Params.Add("Content-Length", strconv.FormatInt(fileinfo.Size(), 10))
-	j.Files["file"] = ci.Filename
-	resp, err := j.Method("POST").Send()
+	fileinfo, err := file.Stat()
 	if err != nil {
-		fmt.Println("Error uploading to S3:", err)
 		return err
 	}
 
-	if resp.StatusCode != 204 {
-		return errors.New(fmt.Sprintf("Invalid status code. %v \n", resp.String()))
+	ext := filepath.Ext(ci.Filename)
+	src := uploadSource{
+		name:        ci.Filename,
+		r:           file,
+		size:        fileinfo.Size(),
+		contentType: mime.TypeByExtension(ext),
 	}
+	return client.uploadToS3(ctx, ci, src)
+}
 
-	return nil
+// uploadSource is the data uploaded for an item
+type uploadSource struct {
+	name        string
+	r           io.Reader
+	size        int64
+	contentType string
+}
+
+// uploadToS3 sends the data to the presigned S3PutUrl or as a browser form
+// POST to S3Url, with SigV4 fields when the API gave them
+func (client Client) uploadToS3(ctx context.Context, ci Item, src uploadSource) error {
+	j := client.newJaguar(ctx)
+	if ci.S3PutUrl != "" {
+		if src.contentType != "" {
+			j.Header.Set("Content-Type", src.contentType)
+		}
+		return client.send(j.WithBody(src.r, src.size).Put(ci.S3PutUrl), nil)
+	}
+
+	j.Url(ci.S3Url)
+	j.Params.Add("key", ci.S3Key)
+	if len(ci.S3Fields) > 0 {
+		// the policy lists the allowed fields, send no others and leave the
+		// values it fixes as they are
+		for k, v := range ci.S3Fields {
+			j.Params.Set(k, v)
+		}
+		if _, ok := ci.S3Fields["Content-Type"]; !ok && src.contentType != "" && policyAllows(ci.S3Fields["policy"], "Content-Type") {
+			j.Params.Set("Content-Type", src.contentType)
+		}
+	} else {
+		j.Params.Add("AWSAccessKeyId", ci.S3AccessKey)
+		j.Params.Add("acl", "public-read")
+		j.Params.Add("policy", ci.S3Policy)
+		j.Params.Add("signature", ci.S3Signature)
+		j.Params.Add("Content-Type", src.contentType)
+		j.Params.Add("Content-Length", strconv.FormatInt(src.size, 10))
+	}
+	j.AddFileReader("file", src.name, src.r)
+	return client.send(j.Method("POST"), nil)
 }
@@ -1,38 +1,164 @@
 package cloudup_test
 
 import (
-	"log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/automattic/go/cloudup"
+	"github.com/automattic/go/cloudup/clouduptest"
 )
 
-// generate basic auth token using Node:
-// console.log(new Buffer("username:password").toString('base64'));
+// tempFile writes data to a temporary file named name
+func tempFile(t *testing.T, name, data string) string {
+	dir, err := ioutil.TempDir("", "cloudup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	filename := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
 
 func TestUpload(t *testing.T) {
-	filename := "--LOCAL FILE PATH--"
-	client := cloudup.Client{}
-	client.BasicToken = "--YOUR BASIC AUTH TOKEN--"
+	server := clouduptest.NewServer()
+	defer server.Close()
+
+	filename := tempFile(t, "gopher.txt", "hola mundo")
+	client := server.Client()
 
 	stream, err := client.CreateStream("Test Stream")
 	if err != nil {
-		log.Fatalf("Error creating stream: %v", err)
+		t.Fatalf("Error creating stream: %v", err)
 	}
 
 	item, err := client.CreateItem(stream.Id, filename, "Test Item")
 	if err != nil {
-		log.Fatalf("Error create item: %v", err)
+		t.Fatalf("Error create item: %v", err)
 	}
 
 	err = client.UploadToS3(item)
 	if err != nil {
-		log.Fatalf("Error uploading to S3: %v", err)
+		t.Fatalf("Error uploading to S3: %v", err)
 	}
 
 	err = client.CompleteItem(item)
 	if err != nil {
-		log.Fatalf("Error marking complete: %v", err)
+		t.Fatalf("Error marking complete: %v", err)
+	}
+
+	data, _ := server.Uploaded(item.Id)
+	if string(data) != "hola mundo" {
+		t.Errorf("Unexpected upload: %q", data)
+	}
+}
+
+// This tests listing streams and items follows pagination
+func TestList(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	for i := 0; i < 5; i++ {
+		if _, err := client.CreateStream("Stream"); err != nil {
+			t.Fatalf("Error creating stream: %v", err)
+		}
+	}
+
+	list, err := client.ListStreams(cloudup.ListOptions{Page: 1, PerPage: 2})
+	if err != nil {
+		t.Fatalf("Error listing streams: %v", err)
+	}
+	if len(list.Streams) != 2 || list.NextPage != 2 {
+		t.Errorf("Unexpected first page: %d streams, next %d", len(list.Streams), list.NextPage)
+	}
+	list, _ = client.ListStreams(cloudup.ListOptions{Page: 3, PerPage: 2})
+	if len(list.Streams) != 1 || list.NextPage != 0 {
+		t.Errorf("Unexpected last page: %d streams, next %d", len(list.Streams), list.NextPage)
+	}
+
+	streams, err := client.AllStreams()
+	if err != nil || len(streams) != 5 {
+		t.Errorf("Expected 5 streams, got %d: %v", len(streams), err)
+	}
+
+	stream := streams[0]
+	for i := 0; i < 3; i++ {
+		if _, err = client.CreateItem(stream.Id, "file.png", "Item"); err != nil {
+			t.Fatalf("Error creating item: %v", err)
+		}
+	}
+	items, err := client.AllItems(stream.Id)
+	if err != nil || len(items) != 3 {
+		t.Fatalf("Expected 3 items, got %d: %v", len(items), err)
+	}
+	if items[0].StreamId != stream.Id || items[0].Mime != "image/png" {
+		t.Errorf("Unexpected item: %+v", items[0])
+	}
+}
+
+// This tests getting, updating, reordering and deleting
+func TestStreamAndItem(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	user, err := client.User()
+	if err != nil || user.Username != "gopher" {
+		t.Errorf("Unexpected user: %+v %v", user, err)
+	}
+
+	stream, _ := client.CreateStream("Old")
+	stream, err = client.UpdateStream(stream.Id, "New")
+	if err != nil || stream.Title != "New" {
+		t.Errorf("Expected title update: %+v %v", stream, err)
+	}
+
+	a, _ := client.CreateItem(stream.Id, "a.txt", "A")
+	b, _ := client.CreateItem(stream.Id, "b.txt", "B")
+
+	item, err := client.UpdateItem(a.Id, "First")
+	if err != nil || item.Title != "First" {
+		t.Errorf("Expected item title update: %+v %v", item, err)
+	}
+	item, err = client.GetItem(a.Id)
+	if err != nil || item.Title != "First" {
+		t.Errorf("Unexpected item: %+v %v", item, err)
 	}
 
+	stream, err = client.ReorderItems(stream.Id, []string{b.Id, a.Id})
+	if err != nil || stream.Items[0] != b.Id {
+		t.Errorf("Expected reordered items: %v %v", stream.Items, err)
+	}
+	if _, err = client.ReorderItems(stream.Id, []string{a.Id}); err == nil {
+		t.Errorf("Expected error reordering with missing items")
+	}
+
+	if err = client.DeleteItem(a.Id); err != nil {
+		t.Errorf("Error deleting item: %v", err)
+	}
+	if _, err = client.GetItem(a.Id); err == nil {
+		t.Errorf("Expected error getting deleted item")
+	}
+	stream, _ = client.GetStream(stream.Id)
+	if len(stream.Items) != 1 {
+		t.Errorf("Expected 1 item left, got %v", stream.Items)
+	}
+
+	if err = client.DeleteStream(stream.Id); err != nil {
+		t.Errorf("Error deleting stream: %v", err)
+	}
+	if _, err = client.GetStream(stream.Id); err == nil {
+		t.Errorf("Expected error getting deleted stream")
+	}
+
+	client.BasicToken = "wrong"
+	if _, err = client.User(); err == nil {
+		t.Errorf("Expected error with wrong credentials")
+	}
 }
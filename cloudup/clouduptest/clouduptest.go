@@ -0,0 +1,817 @@
+// Package clouduptest provides an in-memory fake of the Cloudup API and its
+// S3 upload endpoint for tests, in the spirit of net/http/httptest.
+package clouduptest
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/automattic/go/cloudup"
+)
+
+// Token is the basic and OAuth token accepted by the fake
+const Token = "clouduptest-token"
+
+const (
+	s3Policy    = "clouduptest-policy"
+	s3Bucket    = "clouduptest"
+	s3Signature = "clouduptest-signature"
+	s3AccessKey = "clouduptest-access-key"
+)
+
+// S3Upload selects how the fake asks clients to upload new items to S3
+type S3Upload int
+
+const (
+	// S3Post is a browser form POST with the legacy policy and signature
+	S3Post S3Upload = iota
+
+	// S3PostV4 is a browser form POST with SigV4 fields
+	S3PostV4
+
+	// S3Put is a PUT to a presigned url
+	S3Put
+)
+
+// Server is a fake Cloudup API, it is safe for concurrent use
+type Server struct {
+	*httptest.Server
+
+	// User is returned by /1/user
+	User cloudup.User
+
+	// S3Upload is the upload method of items created from now on, items
+	// can always be uploaded with an S3 multipart upload too
+	S3Upload S3Upload
+
+	mu         sync.Mutex
+	failures   []failure
+	nextId     int
+	streams    map[string]*cloudup.Stream
+	order      []string
+	items      map[string]*cloudup.Item
+	uploads    map[string][]byte
+	multiparts map[string]*multipart
+
+	// SigV4 POST policies issued by S3 key
+	policies map[string]string
+}
+
+// multipart is an S3 multipart upload in progress
+type multipart struct {
+	key   string
+	parts map[int][]byte
+}
+
+// NewServer starts a fake Cloudup API, the caller should Close it
+func NewServer() *Server {
+	s := &Server{
+		User:    cloudup.User{Id: "u1", Username: "gopher", Name: "Gopher", Email: "gopher@example.com"},
+		streams: map[string]*cloudup.Stream{},
+		items:   map[string]*cloudup.Item{},
+		uploads: map[string][]byte{},
+
+		multiparts: map[string]*multipart{},
+		policies:   map[string]string{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/1/user", route(map[string]http.HandlerFunc{
+		"GET": s.auth(s.getUser),
+	}))
+	mux.HandleFunc("/1/streams", route(map[string]http.HandlerFunc{
+		"GET":  s.auth(s.listStreams),
+		"POST": s.auth(s.createStream),
+	}))
+	mux.HandleFunc("/1/streams/", route(map[string]http.HandlerFunc{
+		"GET":    s.auth(s.getStream),
+		"PATCH":  s.auth(s.updateStream),
+		"DELETE": s.auth(s.deleteStream),
+	}))
+	mux.HandleFunc("/1/items", route(map[string]http.HandlerFunc{
+		"GET":  s.auth(s.listItems),
+		"POST": s.auth(s.createItem),
+	}))
+	mux.HandleFunc("/1/items/", route(map[string]http.HandlerFunc{
+		"GET":    s.auth(s.getItem),
+		"POST":   s.auth(s.startMultipart),
+		"PATCH":  s.auth(s.updateItem),
+		"DELETE": s.auth(s.deleteItem),
+	}))
+	mux.HandleFunc("/s3", route(map[string]http.HandlerFunc{
+		"POST": s.s3Post,
+	}))
+	mux.HandleFunc("/s3/", route(map[string]http.HandlerFunc{
+		"PUT":    s.presigned(s.s3Put),
+		"POST":   s.presigned(s.s3Complete),
+		"DELETE": s.presigned(s.s3Abort),
+	}))
+	mux.HandleFunc("/d/", route(map[string]http.HandlerFunc{
+		"GET":  s.download,
+		"HEAD": s.download,
+	}))
+
+	s.Server = httptest.NewServer(s.inject(mux))
+	return s
+}
+
+type failure struct {
+	method string
+	path   string
+	status int
+	times  int
+}
+
+// Fail makes the next times requests with the method and a path starting
+// with path answer with status, to test retries and error handling
+func (s *Server) Fail(method, path string, status, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{method, path, status, times})
+}
+
+func (s *Server) inject(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		for i := range s.failures {
+			f := &s.failures[i]
+			if f.times > 0 && f.method == r.Method && strings.HasPrefix(r.URL.Path, f.path) {
+				f.times--
+				s.mu.Unlock()
+				writeError(w, f.status, "injected", http.StatusText(f.status))
+				return
+			}
+		}
+		s.mu.Unlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// route dispatches on the request method
+func route(methods map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := methods[r.Method]
+		if !ok {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" not allowed")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// pathId returns the last segment of the request path
+func pathId(r *http.Request) string {
+	return r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+}
+
+// Client returns a client authenticated with Token for the fake
+func (s *Server) Client() cloudup.Client {
+	return cloudup.Client{BasicToken: Token, BaseURL: s.URL}
+}
+
+// Streams returns a copy of every stream, in creation order
+func (s *Server) Streams() []cloudup.Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var streams []cloudup.Stream
+	for _, id := range s.order {
+		streams = append(streams, *s.streams[id])
+	}
+	return streams
+}
+
+// Items returns a copy of every item of a stream, in stream order
+func (s *Server) Items(streamId string) []cloudup.Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []cloudup.Item
+	if cs, ok := s.streams[streamId]; ok {
+		for _, id := range cs.Items {
+			items = append(items, *s.items[id])
+		}
+	}
+	return items
+}
+
+// Uploaded returns the data uploaded to S3 for an item
+func (s *Server) Uploaded(itemId string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ci, ok := s.items[itemId]
+	if !ok {
+		return nil, false
+	}
+	data, ok := s.uploads[ci.S3Key]
+	return data, ok
+}
+
+// MultipartUploads returns the number of S3 multipart uploads neither
+// completed nor aborted
+func (s *Server) MultipartUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.multiparts)
+}
+
+func (s *Server) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a := r.Header.Get("Authorization")
+		if a != "Basic "+Token && a != "Bearer "+Token {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid credentials")
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		h(w, r)
+	}
+}
+
+func (s *Server) id(prefix string) string {
+	s.nextId++
+	return prefix + strconv.Itoa(s.nextId)
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.User)
+}
+
+func (s *Server) listStreams(w http.ResponseWriter, r *http.Request) {
+	streams := []cloudup.Stream{}
+	for _, id := range s.order {
+		streams = append(streams, *s.streams[id])
+	}
+	start, end := paginate(w, r, len(streams))
+	writeJSON(w, http.StatusOK, streams[start:end])
+}
+
+func (s *Server) createStream(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	cs := &cloudup.Stream{
+		Id:        s.id("s"),
+		Title:     formValue(r, "title"),
+		Items:     []string{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	cs.Url = s.URL + "/" + cs.Id
+	s.streams[cs.Id] = cs
+	s.order = append(s.order, cs.Id)
+	writeJSON(w, http.StatusCreated, cs)
+}
+
+func (s *Server) getStream(w http.ResponseWriter, r *http.Request) {
+	cs, ok := s.streams[pathId(r)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "stream not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, cs)
+}
+
+func (s *Server) updateStream(w http.ResponseWriter, r *http.Request) {
+	cs, ok := s.streams[pathId(r)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "stream not found")
+		return
+	}
+
+	var update struct {
+		Title *string  `json:"title"`
+		Items []string `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	if update.Items != nil {
+		if !sameItems(cs.Items, update.Items) {
+			writeError(w, http.StatusUnprocessableEntity, "invalid_items", "items must list every item of the stream")
+			return
+		}
+		cs.Items = update.Items
+	}
+	if update.Title != nil {
+		cs.Title = *update.Title
+	}
+	cs.UpdatedAt = time.Now().UTC()
+	writeJSON(w, http.StatusOK, cs)
+}
+
+func (s *Server) deleteStream(w http.ResponseWriter, r *http.Request) {
+	cs, ok := s.streams[pathId(r)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "stream not found")
+		return
+	}
+	for _, id := range cs.Items {
+		delete(s.uploads, s.items[id].S3Key)
+		delete(s.items, id)
+	}
+	delete(s.streams, cs.Id)
+	for i, id := range s.order {
+		if id == cs.Id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listItems(w http.ResponseWriter, r *http.Request) {
+	cs, ok := s.streams[r.FormValue("stream_id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "stream not found")
+		return
+	}
+	items := []cloudup.Item{}
+	for _, id := range cs.Items {
+		items = append(items, s.public(s.items[id]))
+	}
+	start, end := paginate(w, r, len(items))
+	writeJSON(w, http.StatusOK, items[start:end])
+}
+
+func (s *Server) createItem(w http.ResponseWriter, r *http.Request) {
+	cs, ok := s.streams[formValue(r, "stream_id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "stream not found")
+		return
+	}
+
+	now := time.Now().UTC()
+	ci := &cloudup.Item{
+		Id:          s.id("i"),
+		StreamId:    cs.Id,
+		Title:       formValue(r, "title"),
+		Filename:    formValue(r, "filename"),
+		Mime:        formValue(r, "mime"),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		S3Url:       s.URL + "/s3",
+		S3Policy:    s3Policy,
+		S3Signature: s3Signature,
+		S3AccessKey: s3AccessKey,
+	}
+	ci.S3Key = "items/" + ci.Id
+	switch s.S3Upload {
+	case S3PostV4:
+		ci.S3Policy, ci.S3Signature, ci.S3AccessKey = "", "", ""
+		ci.S3Fields = map[string]string{
+			"acl":              "public-read",
+			"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+			"x-amz-credential": s3AccessKey + "/" + now.Format("20060102") + "/us-east-1/s3/aws4_request",
+			"x-amz-date":       now.Format("20060102T150405Z"),
+		}
+		if ci.Mime != "" {
+			ci.S3Fields["Content-Type"] = ci.Mime
+		}
+
+		// every field but the policy and signature is fixed by the policy
+		conditions := []interface{}{map[string]string{"bucket": s3Bucket}, map[string]string{"key": ci.S3Key}}
+		for k, v := range ci.S3Fields {
+			conditions = append(conditions, map[string]string{k: v})
+		}
+		policy, _ := json.Marshal(map[string]interface{}{
+			"expiration": now.Add(time.Hour).Format(time.RFC3339),
+			"conditions": conditions,
+		})
+		ci.S3Fields["policy"] = base64.StdEncoding.EncodeToString(policy)
+		ci.S3Fields["x-amz-signature"] = s3Signature
+		s.policies[ci.S3Key] = ci.S3Fields["policy"]
+	case S3Put:
+		ci.S3Policy, ci.S3Signature, ci.S3AccessKey = "", "", ""
+		ci.S3PutUrl = s.presign(ci.S3Key, nil)
+	}
+	ci.Url = s.URL + "/" + ci.Id
+	ci.DirectUrl = s.URL + "/d/" + ci.Id
+	s.items[ci.Id] = ci
+	cs.Items = append(cs.Items, ci.Id)
+	writeJSON(w, http.StatusCreated, ci)
+}
+
+// startMultipart starts an S3 multipart upload for an item, answering
+// presigned urls for its parts
+func (s *Server) startMultipart(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/1/items/")
+	if !strings.HasSuffix(id, "/multipart") {
+		writeError(w, http.StatusNotFound, "not_found", "not found")
+		return
+	}
+	ci, ok := s.items[strings.TrimSuffix(id, "/multipart")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "item not found")
+		return
+	}
+
+	size, _ := strconv.ParseInt(formValue(r, "size"), 10, 64)
+	partSize, _ := strconv.ParseInt(formValue(r, "part_size"), 10, 64)
+	if size <= 0 || partSize <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_size", "size and part_size are required")
+		return
+	}
+
+	uploadId := s.id("mp")
+	s.multiparts[uploadId] = &multipart{key: ci.S3Key, parts: map[int][]byte{}}
+
+	type part struct {
+		Number int    `json:"number"`
+		Url    string `json:"url"`
+	}
+	var parts []part
+	for n := 1; int64(n-1)*partSize < size; n++ {
+		q := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadId}}
+		parts = append(parts, part{n, s.presign(ci.S3Key, q)})
+	}
+	q := url.Values{"uploadId": {uploadId}}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"upload_id":    uploadId,
+		"parts":        parts,
+		"complete_url": s.presign(ci.S3Key, q),
+		"abort_url":    s.presign(ci.S3Key, q),
+	})
+}
+
+func (s *Server) getItem(w http.ResponseWriter, r *http.Request) {
+	ci, ok := s.items[pathId(r)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "item not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.public(ci))
+}
+
+func (s *Server) updateItem(w http.ResponseWriter, r *http.Request) {
+	ci, ok := s.items[pathId(r)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "item not found")
+		return
+	}
+
+	var update struct {
+		Title    *string `json:"title"`
+		Complete *bool   `json:"complete"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	if update.Complete != nil && *update.Complete {
+		data, ok := s.uploads[ci.S3Key]
+		if !ok {
+			writeError(w, http.StatusConflict, "not_uploaded", "item has not been uploaded")
+			return
+		}
+		ci.Complete = true
+		ci.Size = int64(len(data))
+	}
+	if update.Title != nil {
+		ci.Title = *update.Title
+	}
+	ci.UpdatedAt = time.Now().UTC()
+	writeJSON(w, http.StatusOK, s.public(ci))
+}
+
+func (s *Server) deleteItem(w http.ResponseWriter, r *http.Request) {
+	ci, ok := s.items[pathId(r)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "item not found")
+		return
+	}
+	if cs, ok := s.streams[ci.StreamId]; ok {
+		for i, id := range cs.Items {
+			if id == ci.Id {
+				cs.Items = append(cs.Items[:i], cs.Items[i+1:]...)
+				break
+			}
+		}
+	}
+	delete(s.uploads, ci.S3Key)
+	delete(s.items, ci.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// s3Post accepts browser form uploads like S3, answering 204
+func (s *Server) s3Post(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("x-amz-algorithm") != "" {
+		s.mu.Lock()
+		policy := s.policies[r.FormValue("key")]
+		s.mu.Unlock()
+		if policy == "" || r.FormValue("policy") != policy || r.FormValue("x-amz-signature") != s3Signature {
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "Invalid according to Policy")
+			return
+		}
+		if msg := checkPolicy(policy, r.MultipartForm.Value); msg != "" {
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "Invalid according to Policy: "+msg)
+			return
+		}
+	} else if r.FormValue("policy") != s3Policy || r.FormValue("signature") != s3Signature ||
+		r.FormValue("AWSAccessKeyId") != s3AccessKey {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.uploads[r.FormValue("key")] = data
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkPolicy matches form fields against the exact conditions of a
+// policy the fake issued as S3 does, every field needs a condition and
+// every condition a field, it returns why the form is rejected
+func checkPolicy(policy string, form url.Values) string {
+	b, _ := base64.StdEncoding.DecodeString(policy)
+	var p struct {
+		Conditions []map[string]string `json:"conditions"`
+	}
+	json.Unmarshal(b, &p)
+
+	conditions := map[string]string{}
+	for _, c := range p.Conditions {
+		for k, v := range c {
+			conditions[strings.ToLower(k)] = v
+		}
+	}
+	for k := range form {
+		if k == "policy" || k == "x-amz-signature" {
+			continue
+		}
+		v, ok := conditions[strings.ToLower(k)]
+		if !ok {
+			return "Extra input fields: " + k
+		}
+		if form.Get(k) != v {
+			return "Policy Condition failed: " + k
+		}
+	}
+	for k := range conditions {
+		if k != "bucket" && !hasField(form, k) {
+			return "Policy Condition failed: missing " + k
+		}
+	}
+	return ""
+}
+
+func hasField(form url.Values, name string) bool {
+	for k := range form {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// presign returns a presigned url for the key with the query q
+func (s *Server) presign(key string, q url.Values) string {
+	if q == nil {
+		q = url.Values{}
+	}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s3AccessKey+"/"+time.Now().UTC().Format("20060102")+"/us-east-1/s3/aws4_request")
+	q.Set("X-Amz-Expires", "3600")
+	q.Set("X-Amz-Signature", s3Signature)
+	return s.URL + "/s3/" + key + "?" + q.Encode()
+}
+
+// presigned checks the signature of requests to presigned urls
+func (s *Server) presigned(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("X-Amz-Signature") != s3Signature || !strings.HasPrefix(q.Get("X-Amz-Credential"), s3AccessKey+"/") {
+			writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided")
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		h(w, r)
+	}
+}
+
+// s3Put stores an object or a part of a multipart upload, answering its
+// ETag like S3
+func (s *Server) s3Put(w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength < 0 {
+		writeS3Error(w, http.StatusLengthRequired, "MissingContentLength", "You must provide the Content-Length HTTP header")
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/s3/")
+	uploadId := r.URL.Query().Get("uploadId")
+	if uploadId == "" {
+		s.uploads[key] = data
+		w.Header().Set("ETag", etag(data))
+		return
+	}
+
+	mp, ok := s.multiparts[uploadId]
+	if !ok || mp.key != key {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000")
+		return
+	}
+	mp.parts[n] = data
+	w.Header().Set("ETag", etag(data))
+}
+
+// s3Complete joins the parts of a multipart upload into the object
+func (s *Server) s3Complete(w http.ResponseWriter, r *http.Request) {
+	uploadId := r.URL.Query().Get("uploadId")
+	mp, ok := s.multiparts[uploadId]
+	if !ok || mp.key != strings.TrimPrefix(r.URL.Path, "/s3/") {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+
+	var complete struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil || len(complete.Parts) == 0 {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed")
+		return
+	}
+
+	var data []byte
+	for i, p := range complete.Parts {
+		if i > 0 && p.PartNumber <= complete.Parts[i-1].PartNumber {
+			writeS3Error(w, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order")
+			return
+		}
+		part, ok := mp.parts[p.PartNumber]
+		if !ok || etag(part) != p.ETag {
+			writeS3Error(w, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found")
+			return
+		}
+		data = append(data, part...)
+	}
+
+	s.uploads[mp.key] = data
+	delete(s.multiparts, uploadId)
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key><ETag>%s</ETag></CompleteMultipartUploadResult>", mp.key, etag(data))
+}
+
+// s3Abort discards a multipart upload
+func (s *Server) s3Abort(w http.ResponseWriter, r *http.Request) {
+	uploadId := r.URL.Query().Get("uploadId")
+	if _, ok := s.multiparts[uploadId]; !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+	delete(s.multiparts, uploadId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var data []byte
+	ci, ok := s.items[pathId(r)]
+	if ok {
+		data, ok = s.uploads[ci.S3Key]
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if ci.Mime != "" {
+		w.Header().Set("Content-Type", ci.Mime)
+	}
+	w.Header().Set("ETag", etag(data))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// public hides the S3 upload fields once an item is complete
+func (s *Server) public(ci *cloudup.Item) cloudup.Item {
+	item := *ci
+	if item.Complete {
+		item.S3Url, item.S3Key, item.S3Policy, item.S3Signature, item.S3AccessKey = "", "", "", "", ""
+		item.S3Fields, item.S3PutUrl = nil, ""
+	}
+	return item
+}
+
+// paginate returns the slice bounds for the requested page and sets the
+// Link header when there is a next page
+func paginate(w http.ResponseWriter, r *http.Request, total int) (start, end int) {
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(r.FormValue("per_page"))
+	if perPage < 1 {
+		perPage = cloudup.DefaultPerPage
+	}
+
+	start = (page - 1) * perPage
+	if start > total {
+		start = total
+	}
+	end = start + perPage
+	if end >= total {
+		return start, total
+	}
+
+	next := *r.URL
+	q := next.Query()
+	q.Set("page", strconv.Itoa(page+1))
+	q.Set("per_page", strconv.Itoa(perPage))
+	next.RawQuery = q.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+	return start, end
+}
+
+// formValue reads a form or JSON body value
+func formValue(r *http.Request, key string) string {
+	if r.Form == nil && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		r.Form = url.Values{}
+		for k, v := range body {
+			r.Form.Set(k, fmt.Sprint(v))
+		}
+	}
+	return r.FormValue(key)
+}
+
+func sameItems(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]int{}
+	for _, id := range a {
+		seen[id]++
+	}
+	for _, id := range b {
+		seen[id]--
+		if seen[id] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("X-Request-Id", strconv.FormatInt(time.Now().UnixNano(), 36))
+	writeJSON(w, status, map[string]string{"code": code, "message": message})
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("X-Amz-Request-Id", strconv.FormatInt(time.Now().UnixNano(), 36))
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
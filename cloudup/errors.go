@@ -0,0 +1,121 @@
+package cloudup
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/automattic/go/jaguar"
+)
+
+var (
+	ErrUnauthorized = errors.New("cloudup: unauthorized")
+	ErrForbidden    = errors.New("cloudup: forbidden")
+	ErrNotFound     = errors.New("cloudup: not found")
+	ErrRateLimited  = errors.New("cloudup: rate limited")
+	ErrQuota        = errors.New("cloudup: quota exceeded")
+)
+
+// APIError is returned for non-2xx responses from the API or S3, use
+// errors.Is with ErrUnauthorized, ErrForbidden, ErrNotFound, ErrRateLimited
+// and ErrQuota to check for common failures
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+
+	// Code and Message are read from the JSON error body of the API or
+	// the XML error body of S3, Message is the raw body otherwise
+	Code      string
+	Message   string
+	RequestId string
+}
+
+func (e *APIError) Error() string {
+	s := fmt.Sprintf("cloudup: %s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code != "" {
+		s += ": " + e.Code
+	}
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	if e.RequestId != "" {
+		s += " (request " + e.RequestId + ")"
+	}
+	return s
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrQuota:
+		return e.StatusCode == http.StatusPaymentRequired || strings.Contains(strings.ToLower(e.Code), "quota")
+	}
+	return false
+}
+
+// Temporary reports whether the request may succeed when sent again
+func (e *APIError) Temporary() bool {
+	// S3 reports these codes in 200 responses too
+	if e.Code == "InternalError" || e.Code == "SlowDown" {
+		return true
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func newAPIError(j *jaguar.Jaguar, resp jaguar.Response) *APIError {
+	e := &APIError{
+		Method:     j.RequestMethod,
+		URL:        redactURL(j.RequestUrl),
+		StatusCode: resp.StatusCode,
+		RequestId:  resp.Header.Get("X-Request-Id"),
+	}
+	if e.RequestId == "" {
+		e.RequestId = resp.Header.Get("X-Amz-Request-Id")
+	}
+
+	var body struct {
+		Code    string `json:"code" xml:"Code"`
+		Message string `json:"message" xml:"Message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(resp.Bytes, &body) == nil || xml.Unmarshal(resp.Bytes, &body) == nil {
+		e.Code, e.Message = body.Code, body.Message
+		if e.Message == "" {
+			e.Message = body.Error
+		}
+		return e
+	}
+
+	e.Message = strings.TrimSpace(resp.String())
+	if len(e.Message) > 200 {
+		e.Message = e.Message[:200] + "..."
+	}
+	return e
+}
+
+// redactURL hides the signature of presigned S3 urls in errors and logs
+func redactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	q := u.Query()
+	if q.Get("X-Amz-Signature") == "" {
+		return rawurl
+	}
+	q.Set("X-Amz-Signature", "REDACTED")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,82 @@
+package cloudup_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/automattic/go/cloudup"
+	"github.com/automattic/go/cloudup/clouduptest"
+)
+
+// This tests API errors carry the status, code, message and request id
+func TestAPIError(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	_, err := client.GetStream("missing")
+	var apiErr *cloudup.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Code != "not_found" || apiErr.Message != "stream not found" || apiErr.RequestId == "" {
+		t.Errorf("Unexpected error: %+v", apiErr)
+	}
+	if !errors.Is(err, cloudup.ErrNotFound) || errors.Is(err, cloudup.ErrUnauthorized) {
+		t.Errorf("Expected not found error: %v", err)
+	}
+
+	client.BasicToken = "wrong"
+	if _, err = client.CreateStream("Test"); !errors.Is(err, cloudup.ErrUnauthorized) {
+		t.Errorf("Expected unauthorized error, got %v", err)
+	}
+}
+
+// This tests S3 XML errors and quota errors
+func TestAPIErrorBodies(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1/streams":
+			w.WriteHeader(http.StatusPaymentRequired)
+			w.Write([]byte(`{"code":"quota_exceeded","message":"upgrade your plan"}`))
+		case "/s3":
+			w.Header().Set("X-Amz-Request-Id", "4442587FB7D0A2F9")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Invalid according to Policy</Message></Error>`))
+		}
+	}))
+	defer ts.Close()
+
+	client := cloudup.Client{BaseURL: ts.URL}
+	_, err := client.CreateStream("Test")
+	if !errors.Is(err, cloudup.ErrQuota) {
+		t.Errorf("Expected quota error, got %v", err)
+	}
+
+	filename := tempFile(t, "a.txt", "a")
+	err = client.UploadToS3(cloudup.Item{Filename: filename, S3Url: ts.URL + "/s3"})
+	var apiErr *cloudup.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "AccessDenied" || apiErr.RequestId != "4442587FB7D0A2F9" || !errors.Is(err, cloudup.ErrForbidden) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+// This tests failures go to the injected logger
+func TestLogger(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+
+	var b bytes.Buffer
+	client := server.Client()
+	client.Logger = slog.New(slog.NewTextHandler(&b, nil))
+
+	client.GetStream("missing")
+	if !strings.Contains(b.String(), "level=WARN") || !strings.Contains(b.String(), "status=404") {
+		t.Errorf("Expected failure to be logged: %s", b.String())
+	}
+}
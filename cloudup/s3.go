@@ -0,0 +1,293 @@
+package cloudup
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Data larger than the multipart threshold is uploaded to S3 in parts,
+// several at a time. S3 allows at most 10000 parts so the part size grows
+// for very large files.
+const (
+	DefaultMultipartThreshold = 64 << 20
+	DefaultPartSize           = 16 << 20
+	DefaultPartWorkers        = 4
+
+	maxParts = 10000
+)
+
+// multipartUpload is an S3 multipart upload started by the API for an
+// item, with presigned urls to upload each part, complete and abort it
+type multipartUpload struct {
+	UploadId    string          `json:"upload_id"`
+	Parts       []multipartPart `json:"parts"`
+	CompleteUrl string          `json:"complete_url"`
+	AbortUrl    string          `json:"abort_url"`
+}
+
+type multipartPart struct {
+	Number int    `json:"number"`
+	Url    string `json:"url"`
+}
+
+// completedPart lists an uploaded part when completing the upload
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// partSize returns the size of the parts to upload size bytes in, or zero
+// to upload them at once
+func (opts *UploadOptions) partSize(size int64) int64 {
+	threshold := opts.MultipartThreshold
+	if threshold == 0 {
+		threshold = DefaultMultipartThreshold
+	}
+	if threshold < 0 || size <= threshold {
+		return 0
+	}
+
+	partSize := opts.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	if least := (size + maxParts - 1) / maxParts; partSize < least {
+		partSize = least
+	}
+	return partSize
+}
+
+// uploadMultipart uploads src in parts of partSize, retrying each part on
+// its own. When the upload fails the parts are discarded.
+func (client Client) uploadMultipart(ctx context.Context, ci Item, src uploadSource, opts *UploadOptions, partSize int64, report func(stage UploadStage, attempt, part int)) error {
+	count := int((src.size + partSize - 1) / partSize)
+
+	var mu multipartUpload
+	err := client.retry(ctx, opts, func(attempt int) (err error) {
+		mu, err = client.startMultipart(ctx, ci, src, partSize)
+		if err != nil && !retryableCreate(err) {
+			return permanent{err}
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("start multipart upload: %w", err)
+	}
+	if len(mu.Parts) != count {
+		return client.abortMultipart(ctx, mu, fmt.Errorf("multipart upload has %d parts, expected %d", len(mu.Parts), count))
+	}
+
+	// parts are read at their offset when possible, otherwise in order
+	// into buffers kept for retries
+	ra, _ := src.r.(io.ReaderAt)
+	var base int64
+	if seeker, ok := src.r.(io.Seeker); ra != nil && ok {
+		if base, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			ra = nil
+		}
+	} else {
+		ra = nil
+	}
+
+	partCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var partErr error
+	fail := func(err error) {
+		once.Do(func() {
+			partErr = err
+			cancel()
+		})
+	}
+
+	type job struct {
+		index int
+		size  int64
+		body  func() io.Reader
+	}
+	jobs := make(chan job)
+	etags := make([]string, count)
+
+	workers := opts.PartWorkers
+	if workers <= 0 {
+		workers = DefaultPartWorkers
+	}
+	if workers > count {
+		workers = count
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for jb := range jobs {
+				part := mu.Parts[jb.index]
+				err := client.retry(partCtx, opts, func(attempt int) (err error) {
+					report(StageUpload, attempt, part.Number)
+					etags[jb.index], err = client.uploadPart(partCtx, part.Url, jb.body(), jb.size)
+					return err
+				})
+				if err != nil {
+					fail(fmt.Errorf("part %d: %w", part.Number, err))
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := range mu.Parts {
+		offset := int64(i) * partSize
+		size := src.size - offset
+		if size > partSize {
+			size = partSize
+		}
+
+		jb := job{index: i, size: size}
+		if ra != nil {
+			jb.body = func() io.Reader { return io.NewSectionReader(ra, base+offset, size) }
+		} else {
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(src.r, buf); err != nil {
+				fail(fmt.Errorf("read part %d: %w", mu.Parts[i].Number, err))
+				break
+			}
+			jb.body = func() io.Reader { return bytes.NewReader(buf) }
+		}
+
+		select {
+		case jobs <- jb:
+		case <-partCtx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if partErr == nil {
+		partErr = ctx.Err()
+	}
+	if partErr != nil {
+		return client.abortMultipart(ctx, mu, partErr)
+	}
+
+	parts := make([]completedPart, count)
+	for i, part := range mu.Parts {
+		parts[i] = completedPart{PartNumber: part.Number, ETag: etags[i]}
+	}
+	err = client.retry(ctx, opts, func(attempt int) error {
+		return client.completeMultipart(ctx, mu.CompleteUrl, parts)
+	})
+	if err != nil {
+		return client.abortMultipart(ctx, mu, fmt.Errorf("complete multipart upload: %w", err))
+	}
+	return nil
+}
+
+// startMultipart asks the API to start a multipart upload for the item
+func (client Client) startMultipart(ctx context.Context, ci Item, src uploadSource, partSize int64) (mu multipartUpload, err error) {
+	j := client.newRequestContext(ctx)
+	j.Params.Add("size", strconv.FormatInt(src.size, 10))
+	j.Params.Add("part_size", strconv.FormatInt(partSize, 10))
+	j.Params.Add("mime", src.contentType)
+
+	err = client.send(j.Post(client.apiURL("/1/items/"+url.PathEscape(ci.Id)+"/multipart")), &mu)
+	return mu, err
+}
+
+// uploadPart PUTs a part to its presigned url, returning its ETag
+func (client Client) uploadPart(ctx context.Context, partUrl string, body io.Reader, size int64) (string, error) {
+	j := client.newJaguar(ctx)
+	resp, err := client.sendResponse(j.WithBody(body, size).Put(partUrl), nil)
+	if err != nil {
+		return "", err
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", permanent{fmt.Errorf("cloudup: PUT %s: no ETag in response", redactURL(partUrl))}
+	}
+	return etag, nil
+}
+
+func (client Client) completeMultipart(ctx context.Context, completeUrl string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	j := client.newJaguar(ctx)
+	j.Header.Set("Content-Type", "application/xml")
+	resp, err := client.sendResponse(j.WithBody(bytes.NewReader(body), int64(len(body))).Post(completeUrl), nil)
+	if err != nil {
+		return err
+	}
+
+	// S3 can fail after answering 200, with the error in the body
+	if bytes.Contains(resp.Bytes, []byte("<Error>")) {
+		return newAPIError(&j, resp)
+	}
+	return nil
+}
+
+// abortMultipart discards the uploaded parts, even when ctx has been
+// cancelled, and returns err
+func (client Client) abortMultipart(ctx context.Context, mu multipartUpload, err error) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	j := client.newJaguar(ctx)
+	if aerr := client.send(j.Delete(mu.AbortUrl), nil); aerr != nil {
+		client.log(ctx, slog.LevelError, "cloudup failed to abort multipart upload", "upload", mu.UploadId, "error", aerr)
+	}
+	return err
+}
+
+// policyAllows reports whether a base64 SigV4 POST policy has a condition
+// on a form field, S3 rejects forms with fields the policy doesn't list
+func policyAllows(policy, field string) bool {
+	b, err := base64.StdEncoding.DecodeString(policy)
+	if err != nil {
+		return false
+	}
+	var p struct {
+		Conditions []json.RawMessage `json:"conditions"`
+	}
+	if json.Unmarshal(b, &p) != nil {
+		return false
+	}
+
+	// conditions are {"field": "value"} or ["eq" or "starts-with", "$field", "value"]
+	for _, c := range p.Conditions {
+		var exact map[string]interface{}
+		if json.Unmarshal(c, &exact) == nil {
+			for k := range exact {
+				if strings.EqualFold(k, field) {
+					return true
+				}
+			}
+			continue
+		}
+		var match []interface{}
+		if json.Unmarshal(c, &match) == nil && len(match) == 3 {
+			if name, ok := match[1].(string); ok && strings.EqualFold(name, "$"+field) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package cloudup_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/automattic/go/cloudup"
+	"github.com/automattic/go/cloudup/clouduptest"
+)
+
+// This tests the SigV4 form POST and presigned PUT uploads
+func TestUploadS3Methods(t *testing.T) {
+	for _, method := range []clouduptest.S3Upload{clouduptest.S3PostV4, clouduptest.S3Put} {
+		server := clouduptest.NewServer()
+		defer server.Close()
+		server.S3Upload = method
+		client := server.Client()
+
+		stream, _ := client.CreateStream("Uploads")
+		filename := tempFile(t, "gopher.txt", "hola mundo")
+
+		// the first attempt fails to check the file is sent again
+		server.Fail("POST", "/s3", http.StatusServiceUnavailable, 1)
+		server.Fail("PUT", "/s3/", http.StatusServiceUnavailable, 1)
+
+		item, err := client.Upload(context.Background(), stream.Id, filename, &cloudup.UploadOptions{Backoff: time.Millisecond})
+		if err != nil {
+			t.Fatalf("Error uploading with %d: %v", method, err)
+		}
+		if data, _ := server.Uploaded(item.Id); string(data) != "hola mundo" {
+			t.Errorf("Unexpected upload with %d: %q", method, data)
+		}
+		if item.S3PutUrl != "" || item.S3Fields != nil {
+			t.Errorf("Expected S3 fields to be hidden: %+v", item)
+		}
+	}
+}
+
+// This tests only the fields the SigV4 policy allows are sent, items
+// without a content type have none in the policy
+func TestUploadS3Policy(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	server.S3Upload = clouduptest.S3PostV4
+	client := server.Client()
+
+	stream, _ := client.CreateStream("Uploads")
+	filename := tempFile(t, "README", "hola mundo")
+
+	item, err := client.CreateItem(stream.Id, filename, "README")
+	if err != nil {
+		t.Fatalf("Error creating item: %v", err)
+	}
+	if err = client.UploadToS3(item); err != nil {
+		t.Fatalf("Error uploading: %v", err)
+	}
+	if data, _ := server.Uploaded(item.Id); string(data) != "hola mundo" {
+		t.Errorf("Unexpected upload: %q", data)
+	}
+}
+
+// This tests large files are sent in parts, retrying failed parts
+func TestUploadMultipart(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	stream, _ := client.CreateStream("Uploads")
+	content := strings.Repeat("0123456789", 105)
+	filename := tempFile(t, "digits.txt", content)
+
+	server.Fail("PUT", "/s3/", http.StatusInternalServerError, 2)
+
+	var mu sync.Mutex
+	parts := map[int]int{}
+	item, err := client.Upload(context.Background(), stream.Id, filename, &cloudup.UploadOptions{
+		Backoff:            time.Millisecond,
+		MultipartThreshold: 200,
+		PartSize:           100,
+		PartWorkers:        3,
+		Progress: func(p cloudup.Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			if p.Stage == cloudup.StageUpload {
+				parts[p.Part]++
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error uploading: %v", err)
+	}
+
+	if data, _ := server.Uploaded(item.Id); string(data) != content {
+		t.Errorf("Unexpected upload of %d bytes", len(data))
+	}
+	if !item.Complete || item.Size != int64(len(content)) {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+	// 11 parts and 2 retries
+	attempts := 0
+	for part, n := range parts {
+		if part < 1 || part > 11 {
+			t.Errorf("Unexpected part %d", part)
+		}
+		attempts += n
+	}
+	if len(parts) != 11 || attempts != 13 {
+		t.Errorf("Expected 11 parts in 13 attempts, got %v", parts)
+	}
+	if n := server.MultipartUploads(); n != 0 {
+		t.Errorf("Expected no multipart upload left, got %d", n)
+	}
+}
+
+// This tests readers without random access are sent in parts too
+func TestUploadMultipartReader(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	stream, _ := client.CreateStream("Uploads")
+	content := strings.Repeat("hola mundo", 50)
+
+	server.Fail("PUT", "/s3/", http.StatusServiceUnavailable, 1)
+	item, err := client.UploadReader(context.Background(), stream.Id, "hola.txt", iotest.OneByteReader(strings.NewReader(content)), &cloudup.UploadOptions{
+		Size:               int64(len(content)),
+		Backoff:            time.Millisecond,
+		MultipartThreshold: 100,
+		PartSize:           64,
+	})
+	if err != nil {
+		t.Fatalf("Error uploading: %v", err)
+	}
+	if data, _ := server.Uploaded(item.Id); string(data) != content {
+		t.Errorf("Unexpected upload of %d bytes", len(data))
+	}
+}
+
+// This tests a failed part aborts the multipart upload and deletes the item
+func TestUploadMultipartAbort(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	stream, _ := client.CreateStream("Uploads")
+	filename := tempFile(t, "digits.txt", strings.Repeat("0123456789", 50))
+
+	server.Fail("PUT", "/s3/", http.StatusForbidden, 1)
+	_, err := client.Upload(context.Background(), stream.Id, filename, &cloudup.UploadOptions{
+		Backoff:            time.Millisecond,
+		MultipartThreshold: 100,
+		PartSize:           100,
+	})
+	if !errors.Is(err, cloudup.ErrForbidden) {
+		t.Fatalf("Expected forbidden error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "clouduptest-signature") {
+		t.Errorf("Expected presigned url to be redacted: %v", err)
+	}
+	if n := server.MultipartUploads(); n != 0 {
+		t.Errorf("Expected multipart upload to be aborted, got %d", n)
+	}
+	if items := server.Items(stream.Id); len(items) != 0 {
+		t.Errorf("Expected item to be deleted, got %d items", len(items))
+	}
+}
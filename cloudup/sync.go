@@ -0,0 +1,518 @@
+package cloudup
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SyncAction is a change Sync makes to bring a directory and a stream in
+// line
+type SyncAction string
+
+const (
+	// SyncUpload uploads a file missing from the stream
+	SyncUpload SyncAction = "upload"
+
+	// SyncUpdate uploads a file which differs from its item and deletes
+	// the old item, the local file wins
+	SyncUpdate SyncAction = "update"
+
+	// SyncDownload downloads an item missing from the directory
+	SyncDownload SyncAction = "download"
+
+	// SyncDeleteRemote deletes an item whose file was deleted since the
+	// last sync, only with SyncOptions.Delete
+	SyncDeleteRemote SyncAction = "delete-remote"
+
+	// SyncDeleteLocal deletes a file whose item was deleted since the
+	// last sync, unless the file changed, only with SyncOptions.Delete
+	SyncDeleteLocal SyncAction = "delete-local"
+)
+
+// downloadPrefix starts the name of files being downloaded, they are
+// ignored when scanning the directory
+const downloadPrefix = ".cloudup-download-"
+
+// SyncOptions control Sync
+type SyncOptions struct {
+	// Include and Exclude are glob patterns matched as for BulkUpload,
+	// against file paths and item names
+	Include []string
+	Exclude []string
+
+	// Delete propagates deletions made since the last sync, which needs a
+	// Manifest. Without it deleted files are downloaded again and deleted
+	// items uploaded again.
+	Delete bool
+
+	// Checksum compares files of the same size as their item with the MD5
+	// ETag of the item's download when the manifest has no checksum
+	Checksum bool
+
+	// DryRun plans the changes without making them
+	DryRun bool
+
+	// Manifest is the path of the file recording the state of the last
+	// sync, shared with BulkUpload
+	Manifest string
+
+	// Workers is the maximum number of changes made at once
+	Workers int
+
+	// Upload options used for every upload, Title and Size are ignored
+	Upload UploadOptions
+
+	// OnChange, when set, is called after each change is made or fails,
+	// one call at a time
+	OnChange func(c SyncChange, err error)
+}
+
+// SyncChange is a planned or made change, Path is relative to the
+// directory with forward slashes and is the name of the item
+type SyncChange struct {
+	Action SyncAction
+	Path   string
+
+	// Item in the stream, zero for uploads
+	Item Item
+
+	// Size of the data transferred, zero for deletions
+	Size int64
+}
+
+// SyncResult lists the changes sorted by path and those which failed
+type SyncResult struct {
+	Changes []SyncChange
+	Failed  map[string]error
+}
+
+// localFile is a file found in the directory
+type localFile struct {
+	path     string
+	info     os.FileInfo
+	checksum string
+}
+
+// Sync brings the directory and the stream in line: files missing from the
+// stream are uploaded, items missing from the directory downloaded and
+// files which differ from their item, by size or checksum, uploaded again.
+// Files and items are matched by the manifest, then by item file name.
+func (client Client) Sync(ctx context.Context, streamId, dir string, opts *SyncOptions) (SyncResult, error) {
+	result := SyncResult{Failed: map[string]error{}}
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+
+	manifest := &Manifest{Files: map[string]ManifestEntry{}}
+	if opts.Manifest != "" {
+		var err error
+		if manifest, err = LoadManifest(opts.Manifest); err != nil {
+			return result, err
+		}
+		if manifest.StreamId != "" && manifest.StreamId != streamId {
+			return result, ErrManifestStream
+		}
+		manifest.StreamId = streamId
+	}
+
+	local, err := scanLocal(dir, opts)
+	if err != nil {
+		return result, err
+	}
+	items, err := client.AllItemsContext(ctx, streamId)
+	if err != nil {
+		return result, err
+	}
+
+	byId := map[string]Item{}
+	byName := map[string]Item{}
+	for _, item := range items {
+		if !item.Complete {
+			continue
+		}
+		byId[item.Id] = item
+		if name := itemName(item); name != "" {
+			if _, ok := byName[name]; !ok {
+				byName[name] = item
+			}
+		}
+	}
+
+	// files, matched with their item by the manifest first
+	claimed := map[string]bool{}
+	files := map[string]ManifestEntry{}
+	for rel, lf := range local {
+		entry, synced := manifest.Files[rel]
+		item, ok := byId[entry.ItemId]
+		if !synced || !ok {
+			item, ok = byName[rel]
+		}
+		if ok && claimed[item.Id] {
+			ok = false
+		}
+
+		switch {
+		case ok:
+			claimed[item.Id] = true
+			differs, err := client.differs(ctx, lf, item, entry, opts.Checksum)
+			if err != nil {
+				result.Failed[rel] = err
+				continue
+			}
+			if differs {
+				result.Changes = append(result.Changes, SyncChange{Action: SyncUpdate, Path: rel, Item: item, Size: lf.info.Size()})
+				continue
+			}
+			files[rel] = ManifestEntry{
+				ItemId:   item.Id,
+				Url:      item.Url,
+				Size:     lf.info.Size(),
+				ModTime:  lf.info.ModTime(),
+				Checksum: lf.checksum,
+			}
+		case synced && opts.Delete && entry.Size == lf.info.Size() && entry.ModTime.Equal(lf.info.ModTime()):
+			// files edited since the last sync are uploaded again instead
+			result.Changes = append(result.Changes, SyncChange{Action: SyncDeleteLocal, Path: rel})
+		default:
+			result.Changes = append(result.Changes, SyncChange{Action: SyncUpload, Path: rel, Size: lf.info.Size()})
+		}
+	}
+
+	// then items without a file
+	syncedIds := map[string]bool{}
+	for _, entry := range manifest.Files {
+		syncedIds[entry.ItemId] = true
+	}
+	for _, item := range items {
+		if !item.Complete || claimed[item.Id] {
+			continue
+		}
+		rel := itemName(item)
+		if byName[rel].Id != item.Id || !matchPatterns(opts.Include, opts.Exclude, rel) {
+			// another item has the name
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			result.Failed[rel] = fmt.Errorf("cloudup: item %s name %q is outside the directory", item.Id, rel)
+			continue
+		}
+		if _, ok := local[rel]; ok {
+			continue
+		}
+
+		if syncedIds[item.Id] && opts.Delete {
+			result.Changes = append(result.Changes, SyncChange{Action: SyncDeleteRemote, Path: rel, Item: item})
+		} else {
+			result.Changes = append(result.Changes, SyncChange{Action: SyncDownload, Path: rel, Item: item, Size: item.Size})
+		}
+	}
+	sort.Slice(result.Changes, func(i, j int) bool { return result.Changes[i].Path < result.Changes[j].Path })
+
+	if opts.DryRun {
+		return result, syncError(result)
+	}
+
+	// entries of changes are replaced as they are made, those of files
+	// and items both gone are dropped
+	for rel, entry := range manifest.Files {
+		if _, ok := local[rel]; !ok && byId[entry.ItemId].Id == "" {
+			delete(manifest.Files, rel)
+		}
+	}
+	for rel, entry := range files {
+		manifest.Files[rel] = entry
+	}
+
+	// changes stop when the manifest can't be saved, the next sync
+	// couldn't tell what was changed
+	var mu sync.Mutex
+	var saveErr error
+	saveFailed := make(chan struct{})
+	save := func() {
+		if opts.Manifest != "" && saveErr == nil {
+			if saveErr = manifest.Save(opts.Manifest); saveErr != nil {
+				close(saveFailed)
+			}
+		}
+	}
+	save()
+	if saveErr != nil {
+		return result, fmt.Errorf("cloudup: save manifest: %w", saveErr)
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultBulkWorkers
+	}
+
+	jobs := make(chan SyncChange)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				mu.Lock()
+				stopped := saveErr != nil
+				mu.Unlock()
+				if stopped {
+					continue
+				}
+
+				entry, err := client.apply(ctx, streamId, dir, c, local[c.Path], opts)
+
+				mu.Lock()
+				if err != nil {
+					result.Failed[c.Path] = err
+				} else {
+					if c.Action == SyncDeleteLocal || c.Action == SyncDeleteRemote {
+						delete(manifest.Files, c.Path)
+					} else {
+						manifest.Files[c.Path] = entry
+					}
+					save()
+				}
+				if opts.OnChange != nil {
+					opts.OnChange(c, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for _, c := range result.Changes {
+		select {
+		case jobs <- c:
+		case <-saveFailed:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if saveErr != nil {
+		return result, fmt.Errorf("cloudup: save manifest: %w", saveErr)
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	return result, syncError(result)
+}
+
+// syncError reports the first failure in path order
+func syncError(result SyncResult) error {
+	if len(result.Failed) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(result.Failed))
+	for path := range result.Failed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return fmt.Errorf("cloudup: %d files failed to sync, %s: %w", len(result.Failed), paths[0], result.Failed[paths[0]])
+}
+
+// apply makes a change, returning the manifest entry of the file
+func (client Client) apply(ctx context.Context, streamId, dir string, c SyncChange, lf *localFile, opts *SyncOptions) (ManifestEntry, error) {
+	path := filepath.Join(dir, filepath.FromSlash(c.Path))
+	switch c.Action {
+	case SyncUpload, SyncUpdate:
+		upload := opts.Upload
+		upload.Title, upload.Size = "", lf.info.Size()
+		item, err := client.uploadAs(ctx, streamId, path, c.Path, &upload)
+		if err != nil {
+			return ManifestEntry{}, err
+		}
+		if c.Action == SyncUpdate {
+			if err = client.DeleteItemContext(ctx, c.Item.Id); err != nil && !errors.Is(err, ErrNotFound) {
+				return ManifestEntry{}, fmt.Errorf("cloudup: delete replaced item %s: %w", c.Item.Id, err)
+			}
+		}
+		sum, err := lf.sum()
+		if err != nil {
+			return ManifestEntry{}, err
+		}
+		return ManifestEntry{ItemId: item.Id, Url: item.Url, Size: lf.info.Size(), ModTime: lf.info.ModTime(), Checksum: sum}, nil
+
+	case SyncDownload:
+		return client.download(ctx, c.Item, path)
+
+	case SyncDeleteRemote:
+		err := client.DeleteItemContext(ctx, c.Item.Id)
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+		return ManifestEntry{}, err
+
+	case SyncDeleteLocal:
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return ManifestEntry{}, err
+	}
+	return ManifestEntry{}, fmt.Errorf("cloudup: unknown sync action %q", c.Action)
+}
+
+// differs reports whether a file differs from its item, by size, by the
+// checksum recorded at the last sync or, with checksum, by the item's ETag
+func (client Client) differs(ctx context.Context, lf *localFile, item Item, entry ManifestEntry, checksum bool) (bool, error) {
+	if lf.info.Size() != item.Size {
+		return true, nil
+	}
+
+	if entry.ItemId == item.Id {
+		// reuse the checksum of a file unchanged since the last sync
+		if entry.Size == lf.info.Size() && entry.ModTime.Equal(lf.info.ModTime()) {
+			lf.checksum = entry.Checksum
+			return false, nil
+		}
+		if entry.Checksum != "" {
+			sum, err := lf.sum()
+			return sum != entry.Checksum, err
+		}
+	}
+
+	if checksum && item.DirectUrl != "" {
+		sum, err := lf.sum()
+		if err != nil {
+			return false, err
+		}
+		etag, err := client.remoteChecksum(ctx, item)
+		return etag != "" && etag != sum, err
+	}
+	return false, nil
+}
+
+// remoteChecksum returns the MD5 of an item from the ETag of its download,
+// or "" when the ETag isn't one, as for multipart uploads
+func (client Client) remoteChecksum(ctx context.Context, item Item) (string, error) {
+	j := client.newJaguar(ctx)
+	resp, err := client.sendResponse(j.Head(item.DirectUrl), nil)
+	if err != nil {
+		return "", err
+	}
+	etag := strings.Trim(resp.Header.Get("ETag"), `"`)
+	if len(etag) != 32 || strings.Contains(etag, "-") {
+		return "", nil
+	}
+	return etag, nil
+}
+
+// download writes an item to path, through a temporary file so an
+// interrupted download leaves no partial file
+func (client Client) download(ctx context.Context, item Item, path string) (ManifestEntry, error) {
+	if item.DirectUrl == "" {
+		return ManifestEntry{}, fmt.Errorf("cloudup: item %s has no direct url", item.Id)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return ManifestEntry{}, err
+	}
+
+	// downloads are streamed to disk rather than read into memory, the
+	// direct url is signed so no credentials are sent
+	j := client.newJaguar(ctx)
+	resp, err := j.Get(item.DirectUrl).Stream()
+	if err != nil {
+		return ManifestEntry{}, fmt.Errorf("cloudup: GET %s: %w", item.DirectUrl, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return ManifestEntry{}, &APIError{Method: "GET", URL: item.DirectUrl, StatusCode: resp.StatusCode}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), downloadPrefix+"*")
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return ManifestEntry{}, fmt.Errorf("cloudup: download %s: %w", item.Id, err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return ManifestEntry{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	return ManifestEntry{
+		ItemId:   item.Id,
+		Url:      item.Url,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// scanLocal lists the files of the directory matching the patterns
+func scanLocal(dir string, opts *SyncOptions) (map[string]*localFile, error) {
+	local := map[string]*localFile{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), downloadPrefix) {
+			return nil
+		}
+		if opts.Manifest != "" && (sameFile(path, opts.Manifest) || sameFile(path, opts.Manifest+".tmp")) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if matchPatterns(opts.Include, opts.Exclude, rel) {
+			local[rel] = &localFile{path: path, info: info}
+		}
+		return nil
+	})
+	return local, err
+}
+
+// sum returns the hex MD5 of the file, computing it once
+func (lf *localFile) sum() (string, error) {
+	if lf.checksum != "" {
+		return lf.checksum, nil
+	}
+	file, err := os.Open(lf.path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	lf.checksum = hex.EncodeToString(hash.Sum(nil))
+	return lf.checksum, nil
+}
+
+// itemName is the path of an item in the directory
+func itemName(item Item) string {
+	if item.Filename != "" {
+		return item.Filename
+	}
+	return item.Title
+}
@@ -0,0 +1,165 @@
+package cloudup_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/automattic/go/cloudup"
+	"github.com/automattic/go/cloudup/clouduptest"
+	"github.com/automattic/go/jaguar"
+)
+
+func syncPlan(result cloudup.SyncResult) string {
+	var plan []string
+	for _, c := range result.Changes {
+		plan = append(plan, string(c.Action)+" "+c.Path)
+	}
+	return strings.Join(plan, ", ")
+}
+
+// This tests a directory and stream are synced both ways and deletions
+// propagate with Delete
+func TestSync(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	ctx := context.Background()
+
+	stream, _ := client.CreateStream("Designs")
+	client.UploadReader(ctx, stream.Id, "remote.txt", strings.NewReader("from the stream"), nil)
+
+	dir := filepath.Dir(tempFile(t, "a.txt", "aaaa"))
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("bbbb"), 0644)
+
+	opts := &cloudup.SyncOptions{Manifest: filepath.Join(dir, ".cloudup-manifest.json"), Delete: true}
+	result, err := client.Sync(ctx, stream.Id, dir, opts)
+	if err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	if plan := syncPlan(result); plan != "upload a.txt, download remote.txt, upload sub/b.txt" {
+		t.Errorf("Unexpected changes: %s", plan)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "remote.txt")); string(data) != "from the stream" {
+		t.Errorf("Unexpected download: %q", data)
+	}
+	var names []string
+	for _, item := range server.Items(stream.Id) {
+		names = append(names, item.Filename)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ", ") != "a.txt, remote.txt, sub/b.txt" {
+		t.Errorf("Unexpected items: %v", names)
+	}
+
+	// nothing changed
+	if result, err = client.Sync(ctx, stream.Id, dir, opts); err != nil || len(result.Changes) != 0 {
+		t.Errorf("Expected no changes, got %s %v", syncPlan(result), err)
+	}
+
+	// an edit of the same size, a local and a remote deletion
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("AAAA"), 0644)
+	os.Chtimes(filepath.Join(dir, "a.txt"), time.Now(), time.Now().Add(time.Minute))
+	os.Remove(filepath.Join(dir, "sub", "b.txt"))
+	for _, item := range server.Items(stream.Id) {
+		if item.Filename == "remote.txt" {
+			client.DeleteItem(item.Id)
+		}
+	}
+
+	dryRun := *opts
+	dryRun.DryRun = true
+	result, err = client.Sync(ctx, stream.Id, dir, &dryRun)
+	want := "update a.txt, delete-local remote.txt, delete-remote sub/b.txt"
+	if err != nil || syncPlan(result) != want {
+		t.Errorf("Unexpected dry run: %s %v", syncPlan(result), err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "remote.txt")); err != nil {
+		t.Errorf("Expected dry run to change nothing: %v", err)
+	}
+
+	var changes []string
+	opts.OnChange = func(c cloudup.SyncChange, err error) {
+		changes = append(changes, string(c.Action))
+	}
+	if result, err = client.Sync(ctx, stream.Id, dir, opts); err != nil || syncPlan(result) != want || len(changes) != 3 {
+		t.Fatalf("Unexpected sync: %s %v", syncPlan(result), err)
+	}
+	items := server.Items(stream.Id)
+	if len(items) != 1 || items[0].Filename != "a.txt" {
+		t.Fatalf("Unexpected items: %+v", items)
+	}
+	if data, _ := server.Uploaded(items[0].Id); string(data) != "AAAA" {
+		t.Errorf("Unexpected upload: %q", data)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "remote.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected remote.txt to be deleted: %v", err)
+	}
+}
+
+// This tests files of the same size are compared by checksum without a
+// manifest
+func TestSyncChecksum(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	ctx := context.Background()
+
+	stream, _ := client.CreateStream("Designs")
+	client.UploadReader(ctx, stream.Id, "a.txt", strings.NewReader("aaaa"), nil)
+	client.UploadReader(ctx, stream.Id, "b.txt", strings.NewReader("bbbb"), nil)
+
+	dir := filepath.Dir(tempFile(t, "a.txt", "aaaa"))
+	ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte("BBBB"), 0644)
+
+	result, err := client.Sync(ctx, stream.Id, dir, &cloudup.SyncOptions{DryRun: true})
+	if err != nil || len(result.Changes) != 0 {
+		t.Errorf("Expected no changes by size, got %s %v", syncPlan(result), err)
+	}
+
+	result, err = client.Sync(ctx, stream.Id, dir, &cloudup.SyncOptions{DryRun: true, Checksum: true})
+	if err != nil || syncPlan(result) != "update b.txt" {
+		t.Errorf("Unexpected changes by checksum: %s %v", syncPlan(result), err)
+	}
+}
+
+// This tests downloads go through Configure like every other request
+func TestSyncDownloadConfigure(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	ctx := context.Background()
+
+	stream, _ := client.CreateStream("Designs")
+	item, _ := client.UploadReader(ctx, stream.Id, "remote.txt", strings.NewReader("from the stream"), nil)
+
+	var mu sync.Mutex
+	var urls []string
+	client.Configure = func(j *jaguar.Jaguar) {
+		j.WithHook(jaguar.HookFunc(func(ctx context.Context, e jaguar.Event) {
+			if e.Type == jaguar.EventDone {
+				mu.Lock()
+				urls = append(urls, e.URL)
+				mu.Unlock()
+			}
+		}))
+	}
+
+	dir := filepath.Dir(tempFile(t, "a.txt", "aaaa"))
+	if _, err := client.Sync(ctx, stream.Id, dir, &cloudup.SyncOptions{Workers: 1}); err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "remote.txt")); string(data) != "from the stream" {
+		t.Errorf("Unexpected download: %q", data)
+	}
+	if !strings.Contains(strings.Join(urls, " "), "/d/"+item.Id) {
+		t.Errorf("Expected the download to be configured, got %v", urls)
+	}
+}
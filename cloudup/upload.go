@@ -0,0 +1,316 @@
+package cloudup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// UploadStage names a step of Upload
+type UploadStage string
+
+const (
+	StageCreate   UploadStage = "create"
+	StageUpload   UploadStage = "upload"
+	StageComplete UploadStage = "complete"
+	StageDone     UploadStage = "done"
+)
+
+const (
+	DefaultUploadRetries = 3
+	DefaultRetryBackoff  = time.Second
+)
+
+// Progress is reported as an upload moves through its stages, Attempt
+// counts from 1 and is more when a stage is retried. Part is the number of
+// the part being sent in a multipart upload, zero otherwise.
+type Progress struct {
+	Stage   UploadStage
+	Attempt int
+	Part    int
+	Size    int64
+	ItemId  string
+}
+
+// UploadOptions control Upload and UploadReader, the zero value uses the
+// file name as title and the default retries
+type UploadOptions struct {
+	Title string
+
+	// ContentType of the data, when empty it is guessed from the file name
+	// extension or sniffed from the first 512 bytes
+	ContentType string
+
+	// Size of the data read by UploadReader, when unknown (zero) the data
+	// is read into memory first to measure it
+	Size int64
+
+	// Retries is the number of times a failed stage is retried, negative
+	// for none. Readers which aren't an io.Seeker are only sent once.
+	Retries int
+
+	// Backoff is the wait before the first retry, doubled for each retry
+	Backoff time.Duration
+
+	// Data larger than MultipartThreshold is sent as an S3 multipart
+	// upload in parts of PartSize, PartWorkers at a time. Zero uses the
+	// defaults and a negative threshold always sends the data at once.
+	MultipartThreshold int64
+	PartSize           int64
+	PartWorkers        int
+
+	// Progress, when set, is called as each stage starts and once done,
+	// one call at a time
+	Progress func(Progress)
+}
+
+// Upload creates an item for the file in the stream, uploads it to S3 and
+// marks it complete, retrying each step on network and server errors. If
+// the upload can't be finished the item is deleted. The returned Item has
+// its public Url set.
+func (client Client) Upload(ctx context.Context, streamId, path string, opts *UploadOptions) (Item, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Item{}, err
+	}
+	defer file.Close()
+
+	fileinfo, err := file.Stat()
+	if err != nil {
+		return Item{}, err
+	}
+
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	src := uploadSource{name: filepath.Base(path), r: file, size: fileinfo.Size(), contentType: opts.ContentType}
+	return client.upload(ctx, streamId, src, opts)
+}
+
+// UploadReader uploads the data read from r as an item named name, as
+// Upload does for files
+func (client Client) UploadReader(ctx context.Context, streamId, name string, r io.Reader, opts *UploadOptions) (Item, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+
+	src := uploadSource{name: name, r: r, size: opts.Size, contentType: opts.ContentType}
+	if src.size <= 0 {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return Item{}, fmt.Errorf("cloudup: read %s: %w", name, err)
+		}
+		src.r = bytes.NewReader(data)
+		src.size = int64(len(data))
+	}
+	return client.upload(ctx, streamId, src, opts)
+}
+
+func (client Client) upload(ctx context.Context, streamId string, src uploadSource, opts *UploadOptions) (Item, error) {
+	title := opts.Title
+	if title == "" {
+		title = src.name
+	}
+
+	if src.contentType == "" {
+		src.contentType = mime.TypeByExtension(filepath.Ext(src.name))
+	}
+	if src.contentType == "" {
+		var err error
+		if src.contentType, src.r, err = sniff(src.r); err != nil {
+			return Item{}, fmt.Errorf("cloudup: read %s: %w", src.name, err)
+		}
+	}
+
+	// seekable readers are rewound to retry the upload
+	rewind := func() error { return errNotRewindable }
+	if seeker, ok := src.r.(io.Seeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			rewind = func() error {
+				_, err := seeker.Seek(start, io.SeekStart)
+				return err
+			}
+		}
+	}
+
+	// parts of multipart uploads report from several goroutines
+	var mu sync.Mutex
+	progress := Progress{Size: src.size}
+	report := func(stage UploadStage, attempt, part int) {
+		if opts.Progress != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			progress.Stage, progress.Attempt, progress.Part = stage, attempt, part
+			opts.Progress(progress)
+		}
+	}
+
+	var ci Item
+	err := client.retry(ctx, opts, func(attempt int) (err error) {
+		report(StageCreate, attempt, 0)
+		ci, err = client.createItem(ctx, streamId, src.name, title, src.contentType)
+		if err != nil && !retryableCreate(err) {
+			return permanent{err}
+		}
+		return err
+	})
+	if err != nil {
+		return Item{}, fmt.Errorf("cloudup: create item for %s: %w", src.name, err)
+	}
+	progress.ItemId = ci.Id
+
+	if partSize := opts.partSize(src.size); partSize > 0 {
+		err = client.uploadMultipart(ctx, ci, src, opts, partSize, report)
+	} else {
+		var uploadErr error
+		err = client.retry(ctx, opts, func(attempt int) error {
+			if attempt > 1 {
+				if err := rewind(); err != nil {
+					return permanent{uploadErr}
+				}
+			}
+			report(StageUpload, attempt, 0)
+			uploadErr = client.uploadToS3(ctx, ci, src)
+			return uploadErr
+		})
+	}
+	if err != nil {
+		return Item{}, client.abort(ctx, ci, fmt.Errorf("cloudup: upload %s: %w", src.name, err))
+	}
+
+	var item Item
+	err = client.retry(ctx, opts, func(attempt int) (err error) {
+		report(StageComplete, attempt, 0)
+		item, err = client.completeItem(ctx, ci)
+		return err
+	})
+	if err != nil {
+		return Item{}, client.abort(ctx, ci, fmt.Errorf("cloudup: complete %s: %w", src.name, err))
+	}
+
+	report(StageDone, 1, 0)
+	return item, nil
+}
+
+var errNotRewindable = errors.New("cloudup: reader can't be rewound")
+
+// permanent marks an error which must not be retried
+type permanent struct {
+	error
+}
+
+func (p permanent) Unwrap() error {
+	return p.error
+}
+
+// sniff detects the content type from the first 512 bytes of r, returning
+// a reader which still yields them
+func sniff(r io.Reader) (string, io.Reader, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err == nil {
+			buf := make([]byte, 512)
+			n, err := io.ReadFull(rs, buf)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return "", nil, err
+			}
+			if _, err = rs.Seek(start, io.SeekStart); err != nil {
+				return "", nil, err
+			}
+			return http.DetectContentType(buf[:n]), rs, nil
+		}
+	}
+
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", nil, err
+	}
+	return http.DetectContentType(head), br, nil
+}
+
+// abort deletes an incomplete item, even when ctx has been cancelled
+func (client Client) abort(ctx context.Context, ci Item, err error) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	j := client.newRequestContext(ctx)
+	if derr := client.send(j.Delete(client.apiURL("/1/items/"+url.PathEscape(ci.Id))), nil); derr != nil {
+		client.log(ctx, slog.LevelError, "cloudup failed to delete incomplete item", "item", ci.Id, "error", derr)
+		return fmt.Errorf("%w (deleting item %s: %v)", err, ci.Id, derr)
+	}
+	return err
+}
+
+// retry calls fn until it succeeds, fails with an error not worth retrying
+// or runs out of retries
+func (client Client) retry(ctx context.Context, opts *UploadOptions, fn func(attempt int) error) error {
+	retries := opts.Retries
+	if retries == 0 {
+		retries = DefaultUploadRetries
+	}
+	backoff := opts.Backoff
+	if backoff == 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || attempt > retries || !retryable(err) {
+			return err
+		}
+		client.log(ctx, slog.LevelInfo, "cloudup retrying", "attempt", attempt, "backoff", backoff, "error", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether a request failing with err may succeed when
+// sent again: network errors, rate limits and server errors
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if _, ok := err.(permanent); ok {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var pe *os.PathError
+	return !errors.As(err, &pe)
+}
+
+// retryableCreate is stricter than retryable as creating an item isn't
+// idempotent, a request which may have created one is only sent again when
+// the API says so. Otherwise a retry could leave a duplicate item behind.
+func retryableCreate(err error) bool {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		// nothing was sent
+		return true
+	}
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Temporary()
+}
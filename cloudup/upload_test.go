@@ -0,0 +1,232 @@
+package cloudup_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/automattic/go/cloudup"
+	"github.com/automattic/go/cloudup/clouduptest"
+)
+
+// This tests Upload runs the whole flow and reports progress
+func TestUploadFlow(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	stream, _ := client.CreateStream("Uploads")
+	filename := tempFile(t, "gopher.txt", "hola mundo")
+
+	var stages []cloudup.UploadStage
+	item, err := client.Upload(context.Background(), stream.Id, filename, &cloudup.UploadOptions{
+		Progress: func(p cloudup.Progress) {
+			stages = append(stages, p.Stage)
+			if p.Size != 10 {
+				t.Errorf("Unexpected size: %d", p.Size)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error uploading: %v", err)
+	}
+
+	if !item.Complete || item.Url == "" || item.Title != "gopher.txt" || item.Size != 10 {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+	want := []cloudup.UploadStage{cloudup.StageCreate, cloudup.StageUpload, cloudup.StageComplete, cloudup.StageDone}
+	if len(stages) != len(want) {
+		t.Fatalf("Unexpected stages: %v", stages)
+	}
+	for i := range want {
+		if stages[i] != want[i] {
+			t.Errorf("Unexpected stages: %v", stages)
+		}
+	}
+}
+
+// This tests server errors are retried
+func TestUploadRetry(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	stream, _ := client.CreateStream("Uploads")
+	filename := tempFile(t, "gopher.txt", "hola mundo")
+
+	server.Fail("POST", "/s3", http.StatusServiceUnavailable, 2)
+	server.Fail("PATCH", "/1/items/", http.StatusInternalServerError, 1)
+
+	attempts := 0
+	item, err := client.Upload(context.Background(), stream.Id, filename, &cloudup.UploadOptions{
+		Backoff: time.Millisecond,
+		Progress: func(p cloudup.Progress) {
+			attempts++
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error uploading: %v", err)
+	}
+	if !item.Complete {
+		t.Errorf("Expected complete item: %+v", item)
+	}
+	// create, 3 uploads, 2 completes and done
+	if attempts != 7 {
+		t.Errorf("Expected 7 progress reports, got %d", attempts)
+	}
+}
+
+// This tests creating an item is only retried when it can't have created
+// one
+func TestUploadCreateRetry(t *testing.T) {
+	creates := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creates++
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "<html>")
+	}))
+	defer ts.Close()
+
+	client := cloudup.NewClient(cloudup.WithBaseURL(ts.URL))
+	_, err := client.UploadReader(context.Background(), "s1", "a.txt", strings.NewReader("a"), &cloudup.UploadOptions{Backoff: time.Millisecond})
+	if err == nil || creates != 1 {
+		t.Errorf("Expected the accepted request not to be sent again, got %d requests: %v", creates, err)
+	}
+
+	// refused connections never reached the API
+	ts.Close()
+	attempts := 0
+	_, err = client.UploadReader(context.Background(), "s1", "a.txt", strings.NewReader("a"), &cloudup.UploadOptions{
+		Retries: 2,
+		Backoff: time.Millisecond,
+		Progress: func(p cloudup.Progress) {
+			attempts++
+		},
+	})
+	if !errors.Is(err, syscall.ECONNREFUSED) || attempts != 3 {
+		t.Errorf("Expected refused connections to be retried, got %d attempts: %v", attempts, err)
+	}
+}
+
+// This tests the item is deleted when the upload fails
+func TestUploadCleanup(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	stream, _ := client.CreateStream("Uploads")
+	filename := tempFile(t, "gopher.txt", "hola mundo")
+
+	server.Fail("POST", "/s3", http.StatusForbidden, 1)
+	_, err := client.Upload(context.Background(), stream.Id, filename, &cloudup.UploadOptions{Backoff: time.Millisecond})
+	if err == nil {
+		t.Fatalf("Expected upload error")
+	}
+	if items := server.Items(stream.Id); len(items) != 0 {
+		t.Errorf("Expected item to be deleted, got %d items", len(items))
+	}
+
+	// failing too often
+	server.Fail("POST", "/s3", http.StatusBadGateway, 3)
+	_, err = client.Upload(context.Background(), stream.Id, filename, &cloudup.UploadOptions{Retries: 2, Backoff: time.Millisecond})
+	if err == nil {
+		t.Fatalf("Expected upload error after retries")
+	}
+	if items := server.Items(stream.Id); len(items) != 0 {
+		t.Errorf("Expected item to be deleted, got %d items", len(items))
+	}
+
+	// cancelled before completion, cleanup still happens
+	ctx, cancel := context.WithCancel(context.Background())
+	server.Fail("PATCH", "/1/items/", http.StatusServiceUnavailable, 1)
+	_, err = client.Upload(ctx, stream.Id, filename, &cloudup.UploadOptions{
+		Backoff: time.Hour,
+		Progress: func(p cloudup.Progress) {
+			if p.Stage == cloudup.StageComplete {
+				cancel()
+			}
+		},
+	})
+	if err == nil {
+		t.Fatalf("Expected error after cancel")
+	}
+	if items := server.Items(stream.Id); len(items) != 0 {
+		t.Errorf("Expected item to be deleted, got %d items", len(items))
+	}
+}
+
+// This tests uploading from readers, sniffing the content type
+func TestUploadReader(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	stream, _ := client.CreateStream("Uploads")
+
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 600)
+	item, err := client.UploadReader(context.Background(), stream.Id, "screenshot", strings.NewReader(png), nil)
+	if err != nil {
+		t.Fatalf("Error uploading: %v", err)
+	}
+	if item.Mime != "image/png" || item.Size != int64(len(png)) {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+	if data, _ := server.Uploaded(item.Id); string(data) != png {
+		t.Errorf("Unexpected upload of %d bytes", len(data))
+	}
+
+	// a stream with known size and explicit content type
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("hola mundo"))
+		pw.Close()
+	}()
+	item, err = client.UploadReader(context.Background(), stream.Id, "hola", iotest.OneByteReader(pr), &cloudup.UploadOptions{
+		Size:        10,
+		ContentType: "text/plain",
+	})
+	if err != nil {
+		t.Fatalf("Error uploading: %v", err)
+	}
+	if item.Mime != "text/plain" || item.Size != 10 {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+}
+
+// This tests readers which can't be rewound aren't retried
+func TestUploadReaderNoRetry(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	stream, _ := client.CreateStream("Uploads")
+	server.Fail("POST", "/s3", http.StatusServiceUnavailable, 1)
+
+	attempts := 0
+	_, err := client.UploadReader(context.Background(), stream.Id, "hola.txt", iotest.OneByteReader(strings.NewReader("hola mundo")), &cloudup.UploadOptions{
+		Size:    10,
+		Backoff: time.Millisecond,
+		Progress: func(p cloudup.Progress) {
+			if p.Stage == cloudup.StageUpload {
+				attempts++
+			}
+		},
+	})
+	if err == nil {
+		t.Fatalf("Expected upload error")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected a single upload attempt, got %d", attempts)
+	}
+	if items := server.Items(stream.Id); len(items) != 0 {
+		t.Errorf("Expected item to be deleted, got %d items", len(items))
+	}
+}
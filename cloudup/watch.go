@@ -0,0 +1,184 @@
+package cloudup
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// DefaultWatchInterval is the time between polls when
+// Watcher.Interval is not set
+const DefaultWatchInterval = 30 * time.Second
+
+// Watcher polls streams and calls OnItem and OnStream with the changes
+// found, the same events a WebhookHandler receives, for when webhooks
+// can't reach the application. The first poll records the current state
+// without reporting events. When a callback fails the state is kept, so the
+// stream's changes are reported again on the next poll.
+type Watcher struct {
+	Client    Client
+	StreamIds []string
+
+	// Interval between polls, defaults to DefaultWatchInterval
+	Interval time.Duration
+
+	OnItem   func(ctx context.Context, e ItemEvent) error
+	OnStream func(ctx context.Context, e StreamEvent) error
+
+	// state of each stream seen by the last poll
+	streams map[string]*watchedStream
+}
+
+type watchedStream struct {
+	stream  Stream
+	items   map[string]Item
+	deleted bool
+}
+
+// Run polls until ctx is done, errors are logged to the client logger and
+// polling continues
+func (w *Watcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := w.Poll(ctx); err != nil && ctx.Err() == nil {
+			w.Client.log(ctx, slog.LevelWarn, "cloudup watch failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll fetches the streams once and reports the changes since the last
+// poll. A stream which fails to load is compared again on the next poll.
+func (w *Watcher) Poll(ctx context.Context) error {
+	if w.streams == nil {
+		w.streams = map[string]*watchedStream{}
+	}
+
+	var errs []error
+	for _, id := range w.StreamIds {
+		if err := w.poll(ctx, id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (w *Watcher) poll(ctx context.Context, streamId string) error {
+	prev, seen := w.streams[streamId]
+	if seen && prev.deleted {
+		return nil
+	}
+
+	stream, err := w.Client.GetStreamContext(ctx, streamId)
+	if errors.Is(err, ErrNotFound) && seen {
+		if err = w.stream(ctx, EventStreamDeleted, prev.stream); err != nil {
+			return err
+		}
+		prev.deleted = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	list, err := w.Client.AllItemsContext(ctx, streamId)
+	if err != nil {
+		return err
+	}
+
+	next := &watchedStream{stream: stream, items: map[string]Item{}}
+	for _, item := range list {
+		next.items[item.Id] = item
+	}
+	if !seen {
+		w.streams[streamId] = next
+		return nil
+	}
+
+	// items created and updated in stream order, then those deleted
+	var errs []error
+	for _, item := range list {
+		old, ok := prev.items[item.Id]
+		switch {
+		case !ok:
+			errs = append(errs, w.item(ctx, EventItemCreated, item))
+		case itemChanged(old, item):
+			errs = append(errs, w.item(ctx, EventItemUpdated, item))
+		}
+	}
+	for _, id := range prev.itemIds() {
+		if _, ok := next.items[id]; !ok {
+			errs = append(errs, w.item(ctx, EventItemDeleted, prev.items[id]))
+		}
+	}
+	if streamChanged(prev.stream, stream) {
+		errs = append(errs, w.stream(ctx, EventStreamUpdated, stream))
+	}
+	if err = errors.Join(errs...); err != nil {
+		return err
+	}
+	w.streams[streamId] = next
+	return nil
+}
+
+// itemIds returns the ids of the items listed, in stream order followed by
+// those missing from the stream sorted
+func (s *watchedStream) itemIds() []string {
+	ids := make([]string, 0, len(s.items))
+	listed := map[string]bool{}
+	for _, id := range s.stream.Items {
+		if _, ok := s.items[id]; ok && !listed[id] {
+			ids = append(ids, id)
+			listed[id] = true
+		}
+	}
+	var rest []string
+	for id := range s.items {
+		if !listed[id] {
+			rest = append(rest, id)
+		}
+	}
+	sort.Strings(rest)
+	return append(ids, rest...)
+}
+
+func (w *Watcher) item(ctx context.Context, t EventType, item Item) error {
+	if w.OnItem == nil {
+		return nil
+	}
+	return w.OnItem(ctx, ItemEvent{Type: t, Time: time.Now(), Item: item})
+}
+
+func (w *Watcher) stream(ctx context.Context, t EventType, stream Stream) error {
+	if w.OnStream == nil {
+		return nil
+	}
+	return w.OnStream(ctx, StreamEvent{Type: t, Time: time.Now(), Stream: stream})
+}
+
+func itemChanged(a, b Item) bool {
+	return !a.UpdatedAt.Equal(b.UpdatedAt) || a.Title != b.Title || a.Complete != b.Complete || a.Size != b.Size
+}
+
+func streamChanged(a, b Stream) bool {
+	if !a.UpdatedAt.Equal(b.UpdatedAt) || a.Title != b.Title || len(a.Items) != len(b.Items) {
+		return true
+	}
+	for i := range a.Items {
+		if a.Items[i] != b.Items[i] {
+			return true
+		}
+	}
+	return false
+}
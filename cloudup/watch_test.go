@@ -0,0 +1,166 @@
+package cloudup_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/automattic/go/cloudup"
+	"github.com/automattic/go/cloudup/clouduptest"
+)
+
+// This tests polling reports the changes between polls
+func TestWatcher(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	ctx := context.Background()
+
+	stream, _ := client.CreateStream("Shared")
+	first, _ := client.UploadReader(ctx, stream.Id, "first.txt", strings.NewReader("first"), nil)
+	second, _ := client.UploadReader(ctx, stream.Id, "second.txt", strings.NewReader("second"), nil)
+
+	var events []string
+	w := &cloudup.Watcher{
+		Client:    client,
+		StreamIds: []string{stream.Id},
+		OnItem: func(ctx context.Context, e cloudup.ItemEvent) error {
+			events = append(events, fmt.Sprintf("%s %s", e.Type, e.Item.Title))
+			return nil
+		},
+		OnStream: func(ctx context.Context, e cloudup.StreamEvent) error {
+			events = append(events, fmt.Sprintf("%s %s", e.Type, e.Stream.Title))
+			return nil
+		},
+	}
+
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("Error polling: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no events on first poll, got %v", events)
+	}
+
+	client.UploadReader(ctx, stream.Id, "third.txt", strings.NewReader("third"), nil)
+	client.UpdateItem(first.Id, "First")
+	client.DeleteItem(second.Id)
+	client.UpdateStream(stream.Id, "Renamed")
+
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("Error polling: %v", err)
+	}
+	want := []string{
+		"item.updated First",
+		"item.created third.txt",
+		"item.deleted second.txt",
+		"stream.updated Renamed",
+	}
+	if strings.Join(events, ", ") != strings.Join(want, ", ") {
+		t.Errorf("Unexpected events: %v", events)
+	}
+
+	events = nil
+	client.DeleteStream(stream.Id)
+	w.Poll(ctx)
+	w.Poll(ctx)
+	if len(events) != 1 || events[0] != "stream.deleted Renamed" {
+		t.Errorf("Unexpected events: %v", events)
+	}
+}
+
+// This tests changes are reported again after a callback fails
+func TestWatcherRetry(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	ctx := context.Background()
+
+	stream, _ := client.CreateStream("Shared")
+	var events []string
+	fail := true
+	w := &cloudup.Watcher{
+		Client:    client,
+		StreamIds: []string{stream.Id},
+		OnItem: func(ctx context.Context, e cloudup.ItemEvent) error {
+			events = append(events, fmt.Sprintf("%s %s", e.Type, e.Item.Title))
+			if fail {
+				return errors.New("database unavailable")
+			}
+			return nil
+		},
+	}
+	w.Poll(ctx)
+
+	client.UploadReader(ctx, stream.Id, "first.txt", strings.NewReader("first"), nil)
+	if err := w.Poll(ctx); err == nil {
+		t.Errorf("Expected callback error")
+	}
+	fail = false
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("Error polling: %v", err)
+	}
+	w.Poll(ctx)
+
+	want := "item.created first.txt, item.created first.txt"
+	if strings.Join(events, ", ") != want {
+		t.Errorf("Unexpected events: %v", events)
+	}
+}
+
+// stripItems drops the items list from streams, as some API responses do
+type stripItems struct{}
+
+func (stripItems) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(r)
+	if err != nil || r.Method != "GET" || !strings.Contains(r.URL.Path, "/streams/") ||
+		strings.HasSuffix(r.URL.Path, "/items") || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	var stream map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&stream)
+	resp.Body.Close()
+	delete(stream, "items")
+	body, _ := json.Marshal(stream)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// This tests deletions are found from the items listed, not the stream
+func TestWatcherDeletedUnlisted(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	ctx := context.Background()
+
+	stream, _ := client.CreateStream("Shared")
+	first, _ := client.UploadReader(ctx, stream.Id, "first.txt", strings.NewReader("first"), nil)
+	client.UploadReader(ctx, stream.Id, "second.txt", strings.NewReader("second"), nil)
+
+	client.Transport = stripItems{}
+	var events []string
+	w := &cloudup.Watcher{
+		Client:    client,
+		StreamIds: []string{stream.Id},
+		OnItem: func(ctx context.Context, e cloudup.ItemEvent) error {
+			events = append(events, fmt.Sprintf("%s %s", e.Type, e.Item.Title))
+			return nil
+		},
+	}
+	w.Poll(ctx)
+
+	client.DeleteItem(first.Id)
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("Error polling: %v", err)
+	}
+	if len(events) != 1 || events[0] != "item.deleted first.txt" {
+		t.Errorf("Unexpected events: %v", events)
+	}
+}
@@ -0,0 +1,225 @@
+package cloudup
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventType names a change to an item or stream
+type EventType string
+
+const (
+	EventItemCreated   EventType = "item.created"
+	EventItemUpdated   EventType = "item.updated"
+	EventItemDeleted   EventType = "item.deleted"
+	EventStreamUpdated EventType = "stream.updated"
+	EventStreamDeleted EventType = "stream.deleted"
+)
+
+// SignatureHeader carries the webhook signature, in the form
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">"
+const SignatureHeader = "X-Cloudup-Signature"
+
+const (
+	// DefaultWebhookTolerance is the maximum age of a webhook signature
+	DefaultWebhookTolerance = 5 * time.Minute
+
+	// maxWebhookBody limits the size of webhook requests
+	maxWebhookBody = 1 << 20
+)
+
+var (
+	ErrInvalidSignature = errors.New("cloudup: invalid webhook signature")
+	ErrSignatureExpired = errors.New("cloudup: webhook signature expired")
+	ErrNoWebhookSecret  = errors.New("cloudup: webhook secret not set")
+)
+
+// ItemEvent reports an item created, updated or deleted. Id is empty for
+// events found by a Watcher.
+type ItemEvent struct {
+	Id   string
+	Type EventType
+	Time time.Time
+	Item Item
+}
+
+// StreamEvent reports a stream updated or deleted
+type StreamEvent struct {
+	Id     string
+	Type   EventType
+	Time   time.Time
+	Stream Stream
+}
+
+// WebhookHandler receives webhook requests, verifies their signature and
+// calls OnItem or OnStream with the event. An error from a callback answers
+// 500 so the event is sent again, events of other types are ignored. Without
+// a Secret every request is refused with a 500.
+type WebhookHandler struct {
+	// Secret shared with Cloudup to sign requests
+	Secret string
+
+	// Tolerance is the maximum age of a signature, zero uses
+	// DefaultWebhookTolerance and a negative value accepts any age
+	Tolerance time.Duration
+
+	OnItem   func(ctx context.Context, e ItemEvent) error
+	OnStream func(ctx context.Context, e StreamEvent) error
+
+	// Logger, when set, receives rejected requests and callback errors
+	Logger *slog.Logger
+}
+
+// webhookEvent is the body of a webhook request
+type webhookEvent struct {
+	Id        string          `json:"id"`
+	Type      EventType       `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxWebhookBody {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if h.Secret == "" {
+		h.log(r.Context(), slog.LevelError, "cloudup webhook rejected", "error", ErrNoWebhookSecret)
+		http.Error(w, "webhook secret not set", http.StatusInternalServerError)
+		return
+	}
+
+	tolerance := h.Tolerance
+	if tolerance == 0 {
+		tolerance = DefaultWebhookTolerance
+	}
+	if err = VerifyWebhook(h.Secret, r.Header.Get(SignatureHeader), body, tolerance); err != nil {
+		h.log(r.Context(), slog.LevelWarn, "cloudup webhook rejected", "error", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var event webhookEvent
+	if err = json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	if err = h.dispatch(r.Context(), event); err != nil {
+		h.log(r.Context(), slog.LevelError, "cloudup webhook failed", "event", event.Id, "type", event.Type, "error", err)
+		http.Error(w, "error handling event", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *WebhookHandler) dispatch(ctx context.Context, event webhookEvent) error {
+	switch event.Type {
+	case EventItemCreated, EventItemUpdated, EventItemDeleted:
+		e := ItemEvent{Id: event.Id, Type: event.Type, Time: event.CreatedAt}
+		if err := json.Unmarshal(event.Data, &e.Item); err != nil {
+			return fmt.Errorf("decode %s: %w", event.Type, err)
+		}
+		if h.OnItem != nil {
+			return h.OnItem(ctx, e)
+		}
+	case EventStreamUpdated, EventStreamDeleted:
+		e := StreamEvent{Id: event.Id, Type: event.Type, Time: event.CreatedAt}
+		if err := json.Unmarshal(event.Data, &e.Stream); err != nil {
+			return fmt.Errorf("decode %s: %w", event.Type, err)
+		}
+		if h.OnStream != nil {
+			return h.OnStream(ctx, e)
+		}
+	}
+	return nil
+}
+
+func (h *WebhookHandler) log(ctx context.Context, level slog.Level, msg string, args ...interface{}) {
+	if h.Logger != nil {
+		h.Logger.Log(ctx, level, msg, args...)
+	}
+}
+
+// SignWebhook returns the SignatureHeader value for a body sent at t
+func SignWebhook(secret string, body []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + webhookMAC(secret, ts, body)
+}
+
+// VerifyWebhook checks a SignatureHeader value against the body, a
+// negative tolerance accepts signatures of any age. An empty secret
+// returns ErrNoWebhookSecret, anyone could sign with it.
+func VerifyWebhook(secret, header string, body []byte, tolerance time.Duration) error {
+	if secret == "" {
+		return ErrNoWebhookSecret
+	}
+
+	var ts string
+	var signatures []string
+	for _, field := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	if ts == "" || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	// several signatures are sent while the secret is rotated
+	expected := webhookMAC(secret, ts, body)
+	valid := false
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); tolerance >= 0 && (age > tolerance || age < -tolerance) {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+func webhookMAC(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,135 @@
+package cloudup_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automattic/go/cloudup"
+)
+
+const webhookSecret = "whsec"
+
+func webhookRequest(body string, signature string) *http.Request {
+	r := httptest.NewRequest("POST", "/hooks/cloudup", strings.NewReader(body))
+	r.Header.Set(cloudup.SignatureHeader, signature)
+	return r
+}
+
+// This tests events are verified, decoded and dispatched
+func TestWebhookHandler(t *testing.T) {
+	var items []cloudup.ItemEvent
+	var streams []cloudup.StreamEvent
+	h := &cloudup.WebhookHandler{
+		Secret: webhookSecret,
+		OnItem: func(ctx context.Context, e cloudup.ItemEvent) error {
+			items = append(items, e)
+			return nil
+		},
+		OnStream: func(ctx context.Context, e cloudup.StreamEvent) error {
+			streams = append(streams, e)
+			return nil
+		},
+	}
+
+	bodies := []string{
+		`{"id":"ev1","type":"item.created","created_at":"2024-03-01T10:00:00Z","data":{"id":"i1","stream_id":"s1","title":"Hola"}}`,
+		`{"id":"ev2","type":"stream.updated","created_at":"2024-03-01T10:01:00Z","data":{"id":"s1","title":"Renamed","items":["i1"]}}`,
+		`{"id":"ev3","type":"user.updated","data":{}}`,
+	}
+	for _, body := range bodies {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, webhookRequest(body, cloudup.SignWebhook(webhookSecret, []byte(body), time.Now())))
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Unexpected status %d for %s", w.Code, body)
+		}
+	}
+
+	if len(items) != 1 || items[0].Id != "ev1" || items[0].Type != cloudup.EventItemCreated ||
+		items[0].Item.Id != "i1" || items[0].Item.StreamId != "s1" || items[0].Time.Minute() != 0 {
+		t.Errorf("Unexpected item events: %+v", items)
+	}
+	if len(streams) != 1 || streams[0].Type != cloudup.EventStreamUpdated || streams[0].Stream.Title != "Renamed" {
+		t.Errorf("Unexpected stream events: %+v", streams)
+	}
+
+	// callback errors are retried by the sender
+	h.OnItem = func(ctx context.Context, e cloudup.ItemEvent) error {
+		return errors.New("database unavailable")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, webhookRequest(bodies[0], cloudup.SignWebhook(webhookSecret, []byte(bodies[0]), time.Now())))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for callback error, got %d", w.Code)
+	}
+}
+
+// This tests requests with bad or old signatures are rejected
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":"ev1","type":"item.deleted","data":{"id":"i1"}}`)
+	now := time.Now()
+
+	tests := []struct {
+		header string
+		err    error
+	}{
+		{cloudup.SignWebhook(webhookSecret, body, now), nil},
+		{cloudup.SignWebhook("other", body, now) + "," + strings.Split(cloudup.SignWebhook(webhookSecret, body, now), ",")[1], nil},
+		{cloudup.SignWebhook("other", body, now), cloudup.ErrInvalidSignature},
+		{cloudup.SignWebhook(webhookSecret, body, now.Add(-time.Hour)), cloudup.ErrSignatureExpired},
+		{"v1=abc", cloudup.ErrInvalidSignature},
+		{"", cloudup.ErrInvalidSignature},
+	}
+	for _, test := range tests {
+		if err := cloudup.VerifyWebhook(webhookSecret, test.header, body, time.Minute); err != test.err {
+			t.Errorf("Unexpected error for %q: %v", test.header, err)
+		}
+	}
+
+	called := false
+	h := &cloudup.WebhookHandler{
+		Secret: webhookSecret,
+		OnItem: func(ctx context.Context, e cloudup.ItemEvent) error {
+			called = true
+			return nil
+		},
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, webhookRequest(string(body), cloudup.SignWebhook("other", body, now)))
+	if w.Code != http.StatusUnauthorized || called {
+		t.Errorf("Expected unsigned request to be rejected, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/hooks/cloudup", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be rejected, got %d", w.Code)
+	}
+}
+
+// This tests an empty secret refuses requests signed with an empty key
+func TestWebhookNoSecret(t *testing.T) {
+	body := []byte(`{"id":"ev1","type":"item.deleted","data":{"id":"i1"}}`)
+	signature := cloudup.SignWebhook("", body, time.Now())
+
+	if err := cloudup.VerifyWebhook("", signature, body, time.Minute); err != cloudup.ErrNoWebhookSecret {
+		t.Errorf("Expected ErrNoWebhookSecret, got %v", err)
+	}
+
+	called := false
+	h := &cloudup.WebhookHandler{
+		OnItem: func(ctx context.Context, e cloudup.ItemEvent) error {
+			called = true
+			return nil
+		},
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, webhookRequest(string(body), signature))
+	if w.Code != http.StatusInternalServerError || called {
+		t.Errorf("Expected request without secret to fail, got %d", w.Code)
+	}
+}
@@ -0,0 +1,250 @@
+// cloudup - command line client for Cloudup built on the cloudup library
+//
+// Usage:
+//
+//	cloudup [flags] upload [upload flags] DIR
+//	cloudup [flags] sync [sync flags] DIR
+//
+// Credentials are read from the -token flag or the CLOUDUP_TOKEN
+// environment variable, base64("username:password"), or -oauth and
+// CLOUDUP_OAUTH_TOKEN for an OAuth token.
+//
+// Examples:
+//
+//	cloudup upload -title Screenshots -include '*.png' ~/Desktop
+//	cloudup upload -stream cDb3t2a5G8o -workers 8 ./exports
+//	cloudup sync -delete -dry-run ~/Designs
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/automattic/go/cloudup"
+)
+
+// DefaultManifest is the manifest file name written in the uploaded directory
+const DefaultManifest = ".cloudup-manifest.json"
+
+type globals struct {
+	token string
+	oauth string
+	api   string
+}
+
+// patterns is a repeatable flag collecting glob patterns
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "cloudup:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	var g globals
+
+	fs := flag.NewFlagSet("cloudup", flag.ContinueOnError)
+	fs.StringVar(&g.token, "token", os.Getenv("CLOUDUP_TOKEN"), "basic auth token, base64 of username:password")
+	fs.StringVar(&g.oauth, "oauth", os.Getenv("CLOUDUP_OAUTH_TOKEN"), "OAuth token")
+	fs.StringVar(&g.api, "api", "", "API base URL")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: cloudup [flags] upload|sync [command flags] DIR")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing command")
+	}
+
+	opts := []cloudup.Option{cloudup.WithBasicToken(g.token), cloudup.WithOAuthToken(g.oauth)}
+	if g.api != "" {
+		opts = append(opts, cloudup.WithBaseURL(g.api))
+	}
+	client := cloudup.NewClient(opts...)
+
+	switch fs.Arg(0) {
+	case "upload":
+		return upload(ctx, client, fs.Args()[1:], stdout)
+	case "sync":
+		return syncDir(ctx, client, fs.Args()[1:], stdout)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", fs.Arg(0))
+	}
+}
+
+func upload(ctx context.Context, client cloudup.Client, args []string, stdout io.Writer) error {
+	var opts cloudup.BulkOptions
+	var include, exclude patterns
+
+	fs := flag.NewFlagSet("cloudup upload", flag.ContinueOnError)
+	streamId := fs.String("stream", "", "id of the stream to upload to, instead of creating one")
+	title := fs.String("title", "", "title of the new stream, defaults to the directory name")
+	fs.Var(&include, "include", "glob pattern of files to upload, repeatable")
+	fs.Var(&exclude, "exclude", "glob pattern of files to skip, repeatable")
+	fs.IntVar(&opts.Workers, "workers", cloudup.DefaultBulkWorkers, "number of concurrent uploads")
+	fs.StringVar(&opts.Manifest, "manifest", "", "manifest file used to resume, defaults to "+DefaultManifest+" in DIR")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: cloudup upload [flags] DIR")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one directory")
+	}
+	dir := fs.Arg(0)
+	opts.Include, opts.Exclude = include, exclude
+
+	if opts.Manifest == "" {
+		opts.Manifest = filepath.Join(dir, DefaultManifest)
+	}
+	if err := findStream(ctx, client, streamId, *title, dir, opts.Manifest); err != nil {
+		return err
+	}
+
+	opts.OnFile = func(path string, item cloudup.Item, err error) {
+		if err != nil {
+			fmt.Fprintf(stdout, "failed   %s: %v\n", path, err)
+			return
+		}
+		fmt.Fprintf(stdout, "uploaded %s %s\n", path, item.Url)
+	}
+
+	result, err := client.BulkUpload(ctx, *streamId, dir, &opts)
+	if len(result.Skipped) > 0 {
+		fmt.Fprintf(stdout, "skipped %d files already uploaded\n", len(result.Skipped))
+	}
+
+	stream, serr := client.GetStreamContext(ctx, *streamId)
+	if serr == nil {
+		fmt.Fprintln(stdout, stream.Url)
+	}
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+func syncDir(ctx context.Context, client cloudup.Client, args []string, stdout io.Writer) error {
+	var opts cloudup.SyncOptions
+	var include, exclude patterns
+
+	fs := flag.NewFlagSet("cloudup sync", flag.ContinueOnError)
+	streamId := fs.String("stream", "", "id of the stream to sync with, instead of creating one")
+	title := fs.String("title", "", "title of the new stream, defaults to the directory name")
+	fs.Var(&include, "include", "glob pattern of files to sync, repeatable")
+	fs.Var(&exclude, "exclude", "glob pattern of files to skip, repeatable")
+	fs.BoolVar(&opts.Delete, "delete", false, "delete items and files deleted on the other side since the last sync")
+	fs.BoolVar(&opts.Checksum, "checksum", false, "compare files of the same size by checksum")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "print the changes without making them")
+	fs.IntVar(&opts.Workers, "workers", cloudup.DefaultBulkWorkers, "number of concurrent transfers")
+	fs.StringVar(&opts.Manifest, "manifest", "", "manifest file recording the last sync, defaults to "+DefaultManifest+" in DIR")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: cloudup sync [flags] DIR")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one directory")
+	}
+	dir := fs.Arg(0)
+	opts.Include, opts.Exclude = include, exclude
+
+	if opts.Manifest == "" {
+		opts.Manifest = filepath.Join(dir, DefaultManifest)
+	}
+	if opts.DryRun && *streamId == "" {
+		// don't create a stream for a dry run
+		manifest, err := cloudup.LoadManifest(opts.Manifest)
+		if err != nil {
+			return err
+		}
+		if *streamId = manifest.StreamId; *streamId == "" {
+			return errors.New("-dry-run needs -stream or a previous sync")
+		}
+	}
+	if err := findStream(ctx, client, streamId, *title, dir, opts.Manifest); err != nil {
+		return err
+	}
+
+	opts.OnChange = func(c cloudup.SyncChange, err error) {
+		if err != nil {
+			fmt.Fprintf(stdout, "failed        %s: %v\n", c.Path, err)
+			return
+		}
+		fmt.Fprintf(stdout, "%-13s %s\n", c.Action, c.Path)
+	}
+
+	result, err := client.Sync(ctx, *streamId, dir, &opts)
+	if opts.DryRun {
+		for _, c := range result.Changes {
+			fmt.Fprintf(stdout, "%-13s %s\n", c.Action, c.Path)
+		}
+		fmt.Fprintf(stdout, "dry run, %d changes\n", len(result.Changes))
+	} else if len(result.Changes) == 0 && err == nil {
+		fmt.Fprintln(stdout, "up to date")
+	}
+	return err
+}
+
+// findStream sets streamId, when empty, to the stream of the manifest of a
+// previous run or to a new stream titled title or after the directory
+func findStream(ctx context.Context, client cloudup.Client, streamId *string, title, dir, manifestPath string) error {
+	if *streamId == "" {
+		manifest, err := cloudup.LoadManifest(manifestPath)
+		if err != nil {
+			return err
+		}
+		*streamId = manifest.StreamId
+	}
+	if *streamId != "" {
+		return nil
+	}
+
+	if title == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		title = filepath.Base(abs)
+	}
+	stream, err := client.CreateStreamContext(ctx, title)
+	if err != nil {
+		return err
+	}
+	*streamId = stream.Id
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/automattic/go/cloudup/clouduptest"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "cloudup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err = ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRunUpload(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+
+	dir := writeFiles(t, map[string]string{
+		"a.png":         "a",
+		"b.png":         "b",
+		"notes.txt":     "notes",
+		"shots/c.png":   "c",
+		"shots/tmp.png": "tmp",
+	})
+	args := []string{"-token", clouduptest.Token, "-api", server.URL, "upload", "-title", "Shots", "-include", "*.png", "-exclude", "tmp.*", "-workers", "2", dir}
+
+	var out bytes.Buffer
+	if err := run(context.Background(), args, &out); err != nil {
+		t.Fatalf("Error: %v\n%s", err, out.String())
+	}
+
+	streams := server.Streams()
+	if len(streams) != 1 || streams[0].Title != "Shots" {
+		t.Fatalf("Expected one stream, got %+v", streams)
+	}
+	if items := server.Items(streams[0].Id); len(items) != 3 {
+		t.Errorf("Expected 3 items, got %d", len(items))
+	}
+	if !strings.HasSuffix(strings.TrimSpace(out.String()), streams[0].Url) {
+		t.Errorf("Expected stream url to be printed: %s", out.String())
+	}
+
+	// resume, only the new file is uploaded to the same stream
+	ioutil.WriteFile(filepath.Join(dir, "d.png"), []byte("d"), 0644)
+	out.Reset()
+	if err := run(context.Background(), args, &out); err != nil {
+		t.Fatalf("Error: %v\n%s", err, out.String())
+	}
+	if len(server.Streams()) != 1 {
+		t.Errorf("Expected resume into the same stream")
+	}
+	if items := server.Items(streams[0].Id); len(items) != 4 {
+		t.Errorf("Expected 4 items, got %d", len(items))
+	}
+	if !strings.Contains(out.String(), "skipped 3 files") {
+		t.Errorf("Expected skipped files: %s", out.String())
+	}
+}
+
+func TestRunSync(t *testing.T) {
+	server := clouduptest.NewServer()
+	defer server.Close()
+
+	dir := writeFiles(t, map[string]string{
+		"a.png":       "a",
+		"shots/b.png": "b",
+	})
+	args := []string{"-token", clouduptest.Token, "-api", server.URL, "sync", "-delete", dir}
+
+	var out bytes.Buffer
+	if err := run(context.Background(), args, &out); err != nil {
+		t.Fatalf("Error: %v\n%s", err, out.String())
+	}
+	streams := server.Streams()
+	if len(streams) != 1 || streams[0].Title != filepath.Base(dir) {
+		t.Fatalf("Expected one stream named after the directory, got %+v", streams)
+	}
+	if items := server.Items(streams[0].Id); len(items) != 2 {
+		t.Errorf("Expected 2 items, got %d", len(items))
+	}
+
+	// a dry run of a local deletion changes nothing
+	os.Remove(filepath.Join(dir, "a.png"))
+	out.Reset()
+	dryRun := append(args[:len(args)-1:len(args)-1], "-dry-run", dir)
+	if err := run(context.Background(), dryRun, &out); err != nil {
+		t.Fatalf("Error: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "delete-remote a.png") || !strings.Contains(out.String(), "dry run, 1 changes") {
+		t.Errorf("Unexpected dry run output: %s", out.String())
+	}
+	if items := server.Items(streams[0].Id); len(items) != 2 {
+		t.Errorf("Expected dry run to keep 2 items, got %d", len(items))
+	}
+
+	out.Reset()
+	if err := run(context.Background(), args, &out); err != nil {
+		t.Fatalf("Error: %v\n%s", err, out.String())
+	}
+	if items := server.Items(streams[0].Id); len(items) != 1 || items[0].Filename != "shots/b.png" {
+		t.Errorf("Expected a.png to be deleted, got %+v", items)
+	}
+}
@@ -0,0 +1,429 @@
+// jaguar - a httpie-like command line client built on the jaguar library
+//
+// Usage:
+//
+//	jaguar [flags] [METHOD] URL [ITEM ...]
+//
+// Items:
+//
+//	Header:Value   request header
+//	key==value     query string parameter
+//	key=value      string field, JSON by default or form with -form
+//	key:=json      raw JSON field, for numbers, booleans, arrays and objects
+//	field@path     file upload, sends a multipart form
+//
+// Examples:
+//
+//	jaguar POST api.example.com/items title=Hello tags:='["a","b"]'
+//	jaguar POST example.com/upload X-Auth:secret filedata@upload.jpg
+//	jaguar -session cookies.json -form POST example.com/wp-login.php log=admin
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/automattic/go/jaguar"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorKey    = "\x1b[34m"
+	colorString = "\x1b[32m"
+	colorValue  = "\x1b[33m"
+	colorHeader = "\x1b[36m"
+	colorStatus = "\x1b[1m"
+)
+
+type options struct {
+	form     bool
+	offline  bool
+	curl     bool
+	verbose  bool
+	download bool
+	output   string
+	session  string
+	auth     string
+	insecure bool
+	color    bool
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "jaguar:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	var opts options
+
+	fs := flag.NewFlagSet("jaguar", flag.ContinueOnError)
+	fs.BoolVar(&opts.form, "form", false, "send fields as a form rather than JSON")
+	fs.BoolVar(&opts.offline, "offline", false, "print the request instead of sending it")
+	fs.BoolVar(&opts.curl, "curl", false, "print an equivalent curl command instead of sending the request")
+	fs.BoolVar(&opts.verbose, "verbose", false, "print the request as well as the response")
+	fs.BoolVar(&opts.download, "download", false, "save the response body to a file")
+	fs.StringVar(&opts.output, "output", "", "file to save the response body to, implies -download")
+	fs.StringVar(&opts.session, "session", "", "file to load and save session cookies")
+	fs.StringVar(&opts.auth, "auth", "", "basic auth credentials as user:password")
+	fs.BoolVar(&opts.insecure, "insecure", false, "skip TLS certificate verification")
+	pretty := fs.String("pretty", "auto", "colorize output: auto, all or none")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: jaguar [flags] [METHOD] URL [ITEM ...]")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch *pretty {
+	case "all":
+		opts.color = true
+	case "auto":
+		opts.color = isTerminal(stdout)
+	}
+
+	if opts.output != "" {
+		opts.download = true
+	}
+
+	j, session, err := buildRequest(fs.Args(), opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.curl {
+		curl, err := j.Curl()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, curl)
+		return nil
+	}
+
+	if opts.offline {
+		dump, err := j.Dump()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n\n", bytes.TrimRight(dump, "\r\n"))
+		return nil
+	}
+
+	// the request is built once so the dump shows what is sent
+	request, err := j.Request()
+	if err != nil {
+		return err
+	}
+	if opts.verbose {
+		dump, err := httputil.DumpRequestOut(request, true)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n\n", bytes.TrimRight(dump, "\r\n"))
+	}
+
+	resp, err := j.Do(request)
+	if err != nil {
+		return err
+	}
+
+	if session != nil {
+		if err = session.Save(); err != nil {
+			return err
+		}
+	}
+
+	if opts.download {
+		return download(resp, j.RequestUrl, opts.output, stdout)
+	}
+
+	printResponse(stdout, resp, opts.color)
+	return nil
+}
+
+// buildRequest parses the method, url and request items
+func buildRequest(args []string, opts options) (*jaguar.Jaguar, *jaguar.Session, error) {
+	if len(args) == 0 {
+		return nil, nil, errors.New("missing URL")
+	}
+
+	method := ""
+	if isMethod(args[0]) {
+		method, args = args[0], args[1:]
+		if len(args) == 0 {
+			return nil, nil, errors.New("missing URL")
+		}
+	}
+
+	rawURL, items := normalizeURL(args[0]), args[1:]
+
+	var session *jaguar.Session
+	var j *jaguar.Jaguar
+	if opts.session != "" {
+		s, err := jaguar.LoadSession(opts.session)
+		if err != nil {
+			return nil, nil, err
+		}
+		session, j = s, s.NewRequest()
+	} else {
+		n := jaguar.New()
+		j = &n
+	}
+
+	if opts.insecure {
+		j.SkipVerify()
+	}
+
+	if opts.auth != "" {
+		user, pass, _ := strings.Cut(opts.auth, ":")
+		j.WithAuth(jaguar.BasicAuth(user, pass))
+	}
+
+	query := url.Values{}
+	data := map[string]interface{}{}
+	hasData, hasRaw := false, false
+
+	for _, item := range items {
+		key, sep, value := splitItem(item)
+		switch sep {
+		case ":":
+			j.Header.Add(key, value)
+		case "==":
+			query.Add(key, value)
+		case "=":
+			hasData = true
+			data[key] = value
+			j.Params.Add(key, value)
+		case ":=":
+			if opts.form {
+				return nil, nil, fmt.Errorf("%q: raw JSON fields can not be sent as a form", item)
+			}
+			var v interface{}
+			if err := json.Unmarshal([]byte(value), &v); err != nil {
+				return nil, nil, fmt.Errorf("%q: invalid JSON: %v", item, err)
+			}
+			hasData, hasRaw = true, true
+			data[key] = v
+		case "@":
+			hasData = true
+			j.Files[key] = value
+		default:
+			return nil, nil, fmt.Errorf("%q: unknown request item", item)
+		}
+	}
+
+	if len(query) > 0 {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		q := u.Query()
+		for k, v := range query {
+			q[k] = append(q[k], v...)
+		}
+		u.RawQuery = q.Encode()
+		rawURL = u.String()
+	}
+
+	if method == "" {
+		method = "GET"
+		if hasData {
+			method = "POST"
+		}
+	}
+
+	// JSON by default unless sending a form or uploading files
+	if hasData && !opts.form && len(j.Files) == 0 {
+		j.JsonData = data
+		j.Json = true
+		j.Params = url.Values{}
+		if j.Header.Get("Accept") == "" {
+			j.Header.Set("Accept", "application/json, */*")
+		}
+	} else if len(j.Files) > 0 && hasRaw {
+		return nil, nil, errors.New("raw JSON fields can not be sent with files")
+	}
+
+	j.Url(rawURL).Method(method)
+	return j, session, nil
+}
+
+// splitItem finds the earliest separator, preferring the longest match
+func splitItem(item string) (key, sep, value string) {
+	seps := []string{":=", "==", "=", ":", "@"}
+	for i := 0; i < len(item); i++ {
+		for _, s := range seps {
+			if strings.HasPrefix(item[i:], s) {
+				return item[:i], s, item[i+len(s):]
+			}
+		}
+	}
+	return item, "", ""
+}
+
+func isMethod(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeURL adds a scheme, and localhost for urls like :3000/path
+func normalizeURL(u string) string {
+	if strings.HasPrefix(u, ":") {
+		u = "localhost" + u
+	}
+	if !strings.Contains(u, "://") {
+		u = "http://" + u
+	}
+	return u
+}
+
+func printResponse(w io.Writer, resp jaguar.Response, color bool) {
+	status := fmt.Sprintf("HTTP %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	fmt.Fprintln(w, paint(status, colorStatus, color))
+
+	keys := make([]string, 0, len(resp.Header))
+	for k := range resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range resp.Header[k] {
+			fmt.Fprintf(w, "%s: %s\n", paint(k, colorHeader, color), v)
+		}
+	}
+	fmt.Fprintln(w)
+
+	body := resp.Bytes
+	mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediatype == "application/json" || strings.HasSuffix(mediatype, "+json") {
+		var b bytes.Buffer
+		if json.Indent(&b, body, "", "    ") == nil {
+			if color {
+				fmt.Fprintln(w, colorizeJSON(b.String()))
+			} else {
+				fmt.Fprintln(w, b.String())
+			}
+			return
+		}
+	}
+
+	if !isText(mediatype, body) {
+		fmt.Fprintf(w, "+-----------------------------------------+\n")
+		fmt.Fprintf(w, "| NOTE: binary data not shown (%d bytes) |\n", len(body))
+		fmt.Fprintf(w, "+-----------------------------------------+\n")
+		return
+	}
+
+	w.Write(body)
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		fmt.Fprintln(w)
+	}
+}
+
+// colorizeJSON highlights keys, strings and other values of indented JSON
+func colorizeJSON(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			str := s[i : end+1]
+			if end+1 < len(s) && s[end+1] == ':' {
+				b.WriteString(paint(str, colorKey, true))
+			} else {
+				b.WriteString(paint(str, colorString, true))
+			}
+			i = end
+		case c == '-' || (c >= '0' && c <= '9') || c == 't' || c == 'f' || c == 'n':
+			end := i
+			for end < len(s) && !strings.ContainsRune(",\n]} ", rune(s[end])) {
+				end++
+			}
+			b.WriteString(paint(s[i:end], colorValue, true))
+			i = end - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func paint(s, color string, enabled bool) string {
+	if !enabled {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func isText(mediatype string, body []byte) bool {
+	if strings.HasPrefix(mediatype, "text/") || strings.HasSuffix(mediatype, "xml") {
+		return true
+	}
+	return !bytes.ContainsRune(body, 0) && strings.HasPrefix(http.DetectContentType(body), "text/")
+}
+
+// download writes the body to output, or a name taken from the response
+func download(resp jaguar.Response, requestUrl, output string, stdout io.Writer) error {
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("download failed: HTTP %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	if output == "" {
+		if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+			output = path.Base(params["filename"])
+		}
+	}
+	if output == "" || output == "." || output == "/" {
+		if u, err := url.Parse(requestUrl); err == nil {
+			output = path.Base(u.Path)
+		}
+	}
+	if output == "" || output == "." || output == "/" {
+		output = "index.html"
+	}
+
+	if err := ioutil.WriteFile(output, resp.Bytes, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Downloaded %d bytes to %s\n", len(resp.Bytes), output)
+	return nil
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitItem(t *testing.T) {
+	tests := []struct {
+		item, key, sep, value string
+	}{
+		{"X-Auth:secret", "X-Auth", ":", "secret"},
+		{"q==golang", "q", "==", "golang"},
+		{"title=Hello", "title", "=", "Hello"},
+		{"count:=3", "count", ":=", "3"},
+		{"filedata@/tmp/upload.jpg", "filedata", "@", "/tmp/upload.jpg"},
+		{"email=foo@example.com", "email", "=", "foo@example.com"},
+		{"Referer:http://example.com", "Referer", ":", "http://example.com"},
+	}
+
+	for _, test := range tests {
+		key, sep, value := splitItem(test.item)
+		if key != test.key || sep != test.sep || value != test.value {
+			t.Errorf("%q: got %q %q %q", test.item, key, sep, value)
+		}
+	}
+}
+
+// This tests JSON fields, headers and query params reach the server
+func TestRunJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&data)
+		data["method"] = r.Method
+		data["auth"] = r.Header.Get("X-Auth")
+		data["q"] = r.URL.Query().Get("q")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	}))
+	defer ts.Close()
+
+	var out bytes.Buffer
+	err := run([]string{"-pretty", "none", ts.URL, "title=Hello", "count:=3", "X-Auth:secret", "q==golang"}, &out)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	for _, expected := range []string{
+		"HTTP 200 OK",
+		`"method": "POST"`,
+		`"title": "Hello"`,
+		`"count": 3`,
+		`"auth": "secret"`,
+		`"q": "golang"`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected output to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+// This tests file items send a multipart form
+func TestRunMultipart(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("filedata")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := ioutil.ReadAll(file)
+		fmt.Fprintf(w, "%s %s %s", r.FormValue("foo"), header.Filename, b)
+	}))
+	defer ts.Close()
+
+	dir, err := os.MkdirTemp("", "jaguar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "upload.txt")
+	ioutil.WriteFile(path, []byte("contents"), 0644)
+
+	var out bytes.Buffer
+	if err = run([]string{"POST", ts.URL, "foo=bar", "filedata@" + path}, &out); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !strings.Contains(out.String(), "bar upload.txt contents") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+}
+
+// This tests verbose mode prints the request it sends
+func TestRunVerbose(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "received %s", b)
+	}))
+	defer ts.Close()
+
+	var out bytes.Buffer
+	err := run([]string{"-verbose", "-form", "-auth", "gopher:secret", ts.URL, "title=Hello"}, &out)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	for _, expected := range []string{
+		"POST / HTTP/1.1",
+		"Authorization: Basic ",
+		"title=Hello",
+		"received title=Hello",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected output to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+// This tests offline mode prints the request without sending it
+func TestRunOffline(t *testing.T) {
+	var out bytes.Buffer
+	err := run([]string{"-offline", "-form", "PUT", "example.com/items/1", "title=Hello"}, &out)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	for _, expected := range []string{
+		"PUT /items/1 HTTP/1.1",
+		"Host: example.com",
+		"Content-Type: application/x-www-form-urlencoded",
+		"title=Hello",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected output to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
@@ -3,44 +3,100 @@
 
 // A library to make it a bit easier to do HTTP fetches using Google AppEngine
 // supports adding headers, posting forms, parameters and uploading files
+//
+// Requests go through urlfetch when built for App Engine (the appengine build
+// tag) and net/http otherwise, so the same code runs and tests everywhere.
+// Fetcher is a thin adapter over jaguar, which builds and sends requests.
 
 package gfetch
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"fmt"
 	"io"
-	"io/ioutil"
-	"mime/multipart"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"time"
 
-	"appengine"
-	"appengine/urlfetch"
+	"github.com/automattic/go/jaguar"
 )
 
+// DefaultDeadline is the time allowed for a fetch
+const DefaultDeadline = 15 * time.Second
+
 type Fetcher struct {
-	Context       appengine.Context
+	Context       context.Context
 	Params        url.Values
 	Header, Files map[string]string
 	Data          map[string]interface{}
+
+	// Transport executes requests, defaults to NewTransport(Context)
+	Transport http.RoundTripper
+
+	// Deadline is the time allowed for a fetch, zero uses DefaultDeadline
+	// and a negative value no deadline
+	Deadline time.Duration
 }
 
 // NewFetcher creates a fetcher request instance
-func NewFetcher(context appengine.Context) (f Fetcher) {
-	f.Context = context
+func NewFetcher(ctx context.Context) (f Fetcher) {
+	f.Context = ctx
 	f.Params = make(url.Values)
 	f.Header = map[string]string{}
 	f.Files = map[string]string{}
 	return f
 }
 
-func GetTimeoutClient(ctx appengine.Context, seconds float64) (client *http.Client) {
-	tr := &urlfetch.Transport{Context: ctx, Deadline: time.Second * time.Duration(seconds)}
-	return &http.Client{Transport: tr}
+// newTransport is replaced in tests to see the context it is given
+var newTransport = NewTransport
+
+// GetTimeoutClient returns a client whose requests each have seconds to
+// complete, zero or less for no timeout
+func GetTimeoutClient(ctx context.Context, seconds float64) (client *http.Client) {
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout <= 0 {
+		return &http.Client{Transport: newTransport(ctx)}
+	}
+	return &http.Client{
+		Transport: &timeoutTransport{ctx: ctx, timeout: timeout},
+		Timeout:   timeout,
+	}
+}
+
+// timeoutTransport gives each request its own deadline, on the request
+// context and on the transport context urlfetch takes it from
+type timeoutTransport struct {
+	ctx     context.Context
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(t.ctx, t.timeout)
+	reqCtx, reqCancel := context.WithTimeout(req.Context(), t.timeout)
+	done := func() {
+		reqCancel()
+		cancel()
+	}
+
+	resp, err := newTransport(ctx).RoundTrip(req.WithContext(reqCtx))
+	if err != nil {
+		done()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: done}
+	return resp, nil
+}
+
+// cancelBody releases the request contexts once the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
 
 type Response struct {
@@ -49,6 +105,22 @@ type Response struct {
 	Header     http.Header
 }
 
+// convenience function to get body result as string
+func (r Response) String() string {
+	return string(r.BodyText)
+}
+
+// StatusError is returned for responses with a non-2xx status, the
+// response is returned alongside it
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("gfetch: unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
 // default Fetch returned results as a string
 func (f Fetcher) Fetch(url, method string) (result string, err error) {
 	bytes, err := f.FetchBytes(url, method)
@@ -56,128 +128,89 @@ func (f Fetcher) Fetch(url, method string) (result string, err error) {
 }
 
 func (f Fetcher) JsonRequest(url, method string) (resp Response, err error) {
-
-	jsonStr, err := json.Marshal(f.Data)
+	j, cancel := f.jaguar(f.Context, method, url)
+	defer cancel()
+	j.JsonData = f.Data
+	rs, err := j.JsonRequest()
 	if err != nil {
 		return resp, err
 	}
-
-	request, err := http.NewRequest(method, url, bytes.NewBuffer(jsonStr))
-
-	// add additional user header values
-	for k, v := range f.Header {
-		request.Header.Add(k, v)
-	}
-
-	// add in application/json header
-	request.Header.Set("Content-Type", "application/json")
-
-	client := urlfetch.Client(f.Context)
-	rs, err := client.Do(request)
-	if err != nil {
-		return resp, err
-	}
-	defer rs.Body.Close()
-
-	resp.StatusCode = rs.StatusCode
-	resp.Header = rs.Header
-	resp.BodyText, _ = ioutil.ReadAll(rs.Body)
-	return resp, nil
+	return newResponse(rs), nil
 }
 
 // Return results as byte array
 // Useful for unmarshaling json, so don't need to cast back to a byte array
+// Data is ignored, Params are sent as they always were
 func (f Fetcher) FetchBytes(url, method string) (result []byte, err error) {
+	f.Data = nil
+	resp, err := f.Do(f.Context, method, url)
+	return resp.BodyText, err
+}
 
-	var reqBody io.Reader
-	var contentType string
-
-	// check if post and add post params
-	if method == "POST" || method == "PATCH" {
-		reqBody, contentType, err = f.createPostBody()
-		if err != nil {
-			return
-		}
-	} else {
-		method = "GET"
-		url = url + "?" + f.Params.Encode()
-
+// Do sends the request with any method and returns the full response.
+// GET, HEAD and OPTIONS send Params in the query string, other methods in
+// the body, as a multipart form when there are Files, or JSON when Data is
+// set. Responses with a non-2xx status return a *StatusError.
+func (f Fetcher) Do(ctx context.Context, method, url string) (resp Response, err error) {
+	j, cancel := f.jaguar(ctx, method, url)
+	defer cancel()
+	if len(f.Files) == 0 && f.Data != nil {
+		j.JsonData = f.Data
+		j.Json = true
 	}
-
-	// build request object
-	transport := &urlfetch.Transport{Context: f.Context, Deadline: time.Duration(15) * time.Second}
-
-	request, err := http.NewRequest(method, url, reqBody)
+	rs, err := j.Send()
 	if err != nil {
-		return
+		return resp, err
 	}
 
-	// need to add header to request for content-type
-	// this sets boundaries and builds proper header type
-	if method == "POST" {
-		request.Header.Add("Content-Type", contentType)
+	resp = newResponse(rs)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, &StatusError{StatusCode: resp.StatusCode, Body: resp.BodyText}
 	}
+	return resp, nil
+}
 
-	// add additional user header values
+// jaguar builds the jaguar request for the fetcher, Data is only sent by
+// JsonRequest and Do, not Fetch or FetchBytes. The deadline is also set on the context, which
+// urlfetch takes it from, cancel releases it once the request is done.
+func (f Fetcher) jaguar(ctx context.Context, method, rawurl string) (j jaguar.Jaguar, cancel context.CancelFunc) {
+	j = jaguar.New()
+	j.Method(method).Url(rawurl)
+	j.Params = f.Params
+	if j.Params == nil {
+		j.Params = make(url.Values)
+	}
 	for k, v := range f.Header {
-		request.Header.Add(k, v)
+		j.Header.Add(k, v)
 	}
-
-	// execute request
-	res, err := transport.RoundTrip(request)
-	if err != nil {
-		return
+	for k, v := range f.Files {
+		j.Files[k] = v
 	}
 
-	// process response
-	defer res.Body.Close()
-	result, err = ioutil.ReadAll(res.Body)
-	if err != nil {
-		return
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
-	return
-}
-
-// create body for post - includes files, params
-func (f Fetcher) createPostBody() (body io.Reader, contentType string, err error) {
-
-	var b bytes.Buffer
-	writer := multipart.NewWriter(&b)
-
-	// add parameters first if there are parameters
-	// Amazon doesn't like params after File
-	// TODO: support multiple values for single parameter
-	for k, _ := range f.Params {
-		_ = writer.WriteField(k, f.Params.Get(k))
+	j.Timeout = f.Deadline
+	if j.Timeout == 0 {
+		j.Timeout = DefaultDeadline
+	} else if j.Timeout < 0 {
+		j.Timeout = 0
 	}
 
-	// add files if we are uploading a file
-	for k, v := range f.Files {
-		file, err := os.Open(v)
-		if err != nil {
-			return nil, "", err
-		}
-
-		part, err := writer.CreateFormFile(k, filepath.Base(v))
-		if err != nil {
-			return nil, "", err
-		}
-
-		_, err = io.Copy(part, file)
-		if err != nil {
-			return nil, "", err
-		}
+	cancel = func() {}
+	if j.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
 	}
+	j.Context = ctx
 
-	err = writer.Close()
-	if err != nil {
-		return
+	j.Transport = f.Transport
+	if j.Transport == nil {
+		j.Transport = newTransport(ctx)
 	}
+	return j, cancel
+}
 
-	// content type might be different due to file uploads
-	contentType = writer.FormDataContentType()
-	body = &b
-	return body, contentType, nil
-
+func newResponse(rs jaguar.Response) Response {
+	return Response{StatusCode: rs.StatusCode, BodyText: rs.Bytes, Header: rs.Header}
 }
@@ -0,0 +1,222 @@
+package gfetch_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/automattic/go/gfetch"
+)
+
+// This tests GET params and headers are sent
+func TestFetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Method+" "+r.FormValue("foo")+" "+r.Header.Get("X-Foo"))
+	}))
+	defer ts.Close()
+
+	f := gfetch.NewFetcher(context.Background())
+	f.Params.Add("foo", "bar")
+	f.Header["X-Foo"] = "baz"
+	result, err := f.Fetch(ts.URL, "GET")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if result != "GET bar baz" {
+		t.Errorf("Unexpected result: %v", result)
+	}
+}
+
+// This tests POST with params and a file upload
+func TestFetchPost(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("Error reading file: %v", err)
+			return
+		}
+		b, _ := ioutil.ReadAll(file)
+		fmt.Fprint(w, r.FormValue("foo")+" "+string(b))
+	}))
+	defer ts.Close()
+
+	tmp, err := ioutil.TempFile("", "gfetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	tmp.WriteString("hola mundo")
+	tmp.Close()
+
+	f := gfetch.NewFetcher(context.Background())
+	f.Params.Add("foo", "bar")
+	f.Files["file"] = tmp.Name()
+	result, err := f.Fetch(ts.URL, "POST")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if result != "bar hola mundo" {
+		t.Errorf("Unexpected result: %v", result)
+	}
+}
+
+// This tests Fetch sends Params and ignores Data, which only JsonRequest
+// and Do send
+func TestFetchIgnoresData(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Content-Type")+" "+r.FormValue("foo"))
+	}))
+	defer ts.Close()
+
+	f := gfetch.NewFetcher(context.Background())
+	f.Params.Add("foo", "bar")
+	f.Data = map[string]interface{}{"name": "gopher"}
+	result, err := f.Fetch(ts.URL, "POST")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if result != "application/x-www-form-urlencoded bar" {
+		t.Errorf("Unexpected result: %v", result)
+	}
+}
+
+// This tests JSON requests return status and headers
+func TestJsonRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&data)
+		w.Header().Set("X-Type", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, data["name"])
+	}))
+	defer ts.Close()
+
+	f := gfetch.NewFetcher(context.Background())
+	f.Data = map[string]interface{}{"name": "gopher"}
+	resp, err := f.JsonRequest(ts.URL, "POST")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated || string(resp.BodyText) != "gopher" || resp.Header.Get("X-Type") != "application/json" {
+		t.Errorf("Unexpected response: %v %s %v", resp.StatusCode, resp.BodyText, resp.Header)
+	}
+}
+
+type countingTransport struct {
+	count int
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.count++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// This tests a custom transport and a cancelled context
+func TestTransportAndContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	tr := &countingTransport{}
+	f := gfetch.NewFetcher(context.Background())
+	f.Transport = tr
+	if _, err := f.Fetch(ts.URL, "GET"); err != nil || tr.count != 1 {
+		t.Errorf("Expected request through custom transport: %v %d", err, tr.count)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f = gfetch.NewFetcher(ctx)
+	if _, err := f.Fetch(ts.URL, "GET"); err == nil {
+		t.Errorf("Expected error for cancelled context")
+	}
+}
+
+// This tests Do with every method returns status, headers and body
+func TestDo(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Query", r.URL.RawQuery)
+
+		if r.Method == "DELETE" {
+			// net/http only parses form bodies for POST, PUT and PATCH
+			b, _ := ioutil.ReadAll(r.Body)
+			r.URL.RawQuery = string(b)
+		}
+		if r.Method != "HEAD" {
+			fmt.Fprint(w, r.FormValue("foo"))
+		}
+	}))
+	defer ts.Close()
+
+	for _, method := range []string{"GET", "HEAD", "OPTIONS", "POST", "PUT", "PATCH", "DELETE"} {
+		f := gfetch.NewFetcher(context.Background())
+		f.Params.Add("foo", "bar")
+		resp, err := f.Do(context.Background(), method, ts.URL)
+		if err != nil {
+			t.Fatalf("%s error: %v", method, err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Method") != method {
+			t.Errorf("%s unexpected response: %v %v", method, resp.StatusCode, resp.Header)
+		}
+		if method != "HEAD" && resp.String() != "bar" {
+			t.Errorf("%s unexpected body: %v", method, resp.String())
+		}
+	}
+
+	// no params, no query string
+	f := gfetch.NewFetcher(context.Background())
+	resp, _ := f.Do(context.Background(), "GET", ts.URL)
+	if resp.Header.Get("X-Query") != "" {
+		t.Errorf("Expected empty query, got %v", resp.Header.Get("X-Query"))
+	}
+}
+
+// This tests non-2xx responses return a StatusError with the response
+func TestStatusError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "not found")
+	}))
+	defer ts.Close()
+
+	f := gfetch.NewFetcher(context.Background())
+	resp, err := f.Do(context.Background(), "DELETE", ts.URL)
+	statusErr, ok := err.(*gfetch.StatusError)
+	if !ok || statusErr.StatusCode != http.StatusNotFound || string(statusErr.Body) != "not found" {
+		t.Fatalf("Expected status error, got %v", err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected response with error, got %v", resp.StatusCode)
+	}
+
+	if _, err = f.FetchBytes(ts.URL, "GET"); err == nil {
+		t.Errorf("Expected FetchBytes error for 404")
+	}
+}
+
+// This tests the deadline is configurable
+func TestDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	f := gfetch.NewFetcher(context.Background())
+	f.Deadline = 10 * time.Millisecond
+	if _, err := f.Fetch(ts.URL, "GET"); err == nil {
+		t.Errorf("Expected deadline error")
+	}
+
+	f.Deadline = time.Second
+	if _, err := f.Fetch(ts.URL, "GET"); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+}
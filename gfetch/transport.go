@@ -0,0 +1,14 @@
+//go:build !appengine
+
+package gfetch
+
+import (
+	"context"
+	"net/http"
+)
+
+// NewTransport returns the transport used for fetches, net/http outside of
+// App Engine
+func NewTransport(ctx context.Context) http.RoundTripper {
+	return http.DefaultTransport
+}
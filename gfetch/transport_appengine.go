@@ -0,0 +1,17 @@
+//go:build appengine
+
+package gfetch
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/appengine/urlfetch"
+)
+
+// NewTransport returns the transport used for fetches, urlfetch on App
+// Engine, ctx must be an App Engine request context. urlfetch takes its
+// deadline from ctx, 5 seconds when it has none.
+func NewTransport(ctx context.Context) http.RoundTripper {
+	return &urlfetch.Transport{Context: ctx}
+}
@@ -0,0 +1,88 @@
+package gfetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// This tests the transport context carries the deadline, as urlfetch only
+// takes it from there
+func TestTransportDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var deadlines []time.Duration
+	newTransport = func(ctx context.Context) http.RoundTripper {
+		if deadline, ok := ctx.Deadline(); ok {
+			deadlines = append(deadlines, time.Until(deadline).Round(time.Second))
+		} else {
+			deadlines = append(deadlines, 0)
+		}
+		return http.DefaultTransport
+	}
+	defer func() { newTransport = NewTransport }()
+
+	f := NewFetcher(context.Background())
+	f.Fetch(ts.URL, "GET")
+	f.Deadline = 30 * time.Second
+	f.Fetch(ts.URL, "GET")
+	f.Deadline = -1
+	f.Fetch(ts.URL, "GET")
+	client := GetTimeoutClient(context.Background(), 5)
+	if resp, err := client.Get(ts.URL); err == nil {
+		resp.Body.Close()
+	}
+
+	want := []time.Duration{DefaultDeadline, 30 * time.Second, 0, 5 * time.Second}
+	if len(deadlines) != len(want) {
+		t.Fatalf("Unexpected deadlines: %v", deadlines)
+	}
+	for i := range want {
+		if deadlines[i] != want[i] {
+			t.Errorf("Expected deadline %v, got %v", want[i], deadlines[i])
+		}
+	}
+}
+
+// contextTransport sends requests with the transport context, like
+// urlfetch does
+type contextTransport struct {
+	ctx context.Context
+}
+
+func (t contextTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return http.DefaultTransport.RoundTrip(r.WithContext(t.ctx))
+}
+
+// This tests the timeout client applies its timeout to each request, not
+// from when the client was created
+func TestTimeoutClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+	}))
+	defer ts.Close()
+
+	newTransport = func(ctx context.Context) http.RoundTripper {
+		return contextTransport{ctx}
+	}
+	defer func() { newTransport = NewTransport }()
+
+	client := GetTimeoutClient(context.Background(), 0.1)
+	time.Sleep(150 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("Error after the client was created: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if _, err := client.Get(ts.URL + "/slow"); err == nil {
+		t.Errorf("Expected slow request to time out")
+	}
+}
@@ -0,0 +1,178 @@
+package gravatar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultProfileURL is the base url of Gravatar profiles
+	DefaultProfileURL = "https://en.gravatar.com"
+
+	// DefaultTimeout limits requests of clients created by NewClient
+	DefaultTimeout = 10 * time.Second
+)
+
+var (
+	ErrProfileNotFound = errors.New("gravatar: profile not found")
+	ErrInvalidResponse = errors.New("gravatar: invalid profile response")
+)
+
+// DefaultClient is used by FetchGravatarProfileByUsername and
+// NewGravatarFromUsername
+var DefaultClient = NewClient()
+
+// Client fetches Gravatar profiles
+type Client struct {
+	// BaseURL of the profiles, defaults to DefaultProfileURL
+	BaseURL string
+
+	// HTTPClient sends the requests, defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Option configures a Client created with NewClient
+type Option func(*Client)
+
+// NewClient creates a client with a DefaultTimeout, configured with opts
+func NewClient(opts ...Option) Client {
+	c := Client{HTTPClient: &http.Client{Timeout: DefaultTimeout}}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithBaseURL fetches profiles from another server, such as a test server
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) { c.BaseURL = strings.TrimRight(baseURL, "/") }
+}
+
+// WithHTTPClient sends requests with hc, for its transport and timeout
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.HTTPClient = hc }
+}
+
+// ProfileByUsername fetches the profile of a WordPress.com username
+func (c Client) ProfileByUsername(ctx context.Context, username string) (GravatarProfile, error) {
+	if username == "" {
+		return GravatarProfile{}, ErrProfileNotFound
+	}
+	return c.profile(ctx, username)
+}
+
+// ProfileByEmail fetches the profile of an email address
+func (c Client) ProfileByEmail(ctx context.Context, email string) (GravatarProfile, error) {
+	return c.ProfileByHash(ctx, HashEmail(email))
+}
+
+// ProfileByHash fetches the profile of a Gravatar hash
+func (c Client) ProfileByHash(ctx context.Context, hash string) (GravatarProfile, error) {
+	if hash == "" {
+		return GravatarProfile{}, ErrProfileNotFound
+	}
+	return c.profile(ctx, hash)
+}
+
+// Format is a profile format besides JSON
+type Format string
+
+const (
+	FormatXML Format = "xml"
+	FormatVCF Format = "vcf"
+	FormatQR  Format = "qr"
+)
+
+// ProfileData fetches the profile of a username or hash in another format:
+// XML, a vCard or a QR code PNG linking to the profile
+func (c Client) ProfileData(ctx context.Context, id string, format Format) ([]byte, error) {
+	if id == "" {
+		return nil, ErrProfileNotFound
+	}
+	return c.get(ctx, url.PathEscape(id)+"."+string(format))
+}
+
+// QRCode fetches a PNG QR code of size pixels linking to the profile of a
+// username or hash, zero for the default size
+func (c Client) QRCode(ctx context.Context, id string, size int) ([]byte, error) {
+	if id == "" {
+		return nil, ErrProfileNotFound
+	}
+	path := url.PathEscape(id) + "." + string(FormatQR)
+	if size > 0 {
+		path += "?s=" + strconv.Itoa(size)
+	}
+	return c.get(ctx, path)
+}
+
+// GravatarFromUsername returns the Gravatar of a WordPress.com username,
+// unlike NewGravatarFromUsername it reports errors
+func (c Client) GravatarFromUsername(ctx context.Context, username string) (Gravatar, error) {
+	gp, err := c.ProfileByUsername(ctx, username)
+	if err != nil {
+		return Gravatar{}, err
+	}
+	return gp.Gravatar(), nil
+}
+
+// profile fetches the JSON profile of a username or hash
+func (c Client) profile(ctx context.Context, id string) (gp GravatarProfile, err error) {
+	body, err := c.get(ctx, url.PathEscape(id)+".json")
+	if err != nil {
+		return gp, err
+	}
+
+	var gr GravatarResponse
+	if err = json.Unmarshal(body, &gr); err != nil {
+		return gp, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	if len(gr.Entry) != 1 {
+		return gp, fmt.Errorf("%w: %d entries", ErrInvalidResponse, len(gr.Entry))
+	}
+	return gr.Entry[0], nil
+}
+
+// get fetches a path of the profile server
+func (c Client) get(ctx context.Context, path string) ([]byte, error) {
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultProfileURL
+	}
+	rawurl := baseURL + "/" + path
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	response, err := hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("gravatar: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, ErrProfileNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gravatar: GET %s: %s", rawurl, response.Status)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("gravatar: GET %s: %w", rawurl, err)
+	}
+	return body, nil
+}
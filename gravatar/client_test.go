@@ -0,0 +1,191 @@
+package gravatar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mkazHash = "fc45b574f01cde91f5d1603335ea77c3"
+
+// mkazProfile is written the way the profile API does, with quoted
+// booleans and [] for an empty object
+const mkazProfile = `{"entry":[{"id":"1","hash":"fc45b574f01cde91f5d1603335ea77c3","requestHash":"mkaz",
+	"profileUrl":"http://gravatar.com/mkaz","preferredUsername":"mkaz",
+	"thumbnailUrl":"https://secure.gravatar.com/avatar/fc45b574f01cde91f5d1603335ea77c3",
+	"photos":[{"value":"https://secure.gravatar.com/avatar/fc45b574f01cde91f5d1603335ea77c3","type":"thumbnail"}],
+	"profileBackground":[],
+	"name":{"givenName":"Marcus","familyName":"Kazmierczak","formatted":"Marcus Kazmierczak"},
+	"displayName":"Marcus Kazmierczak","aboutMe":"Code Wrangler","currentLocation":"Carlsbad, CA",
+	"phoneNumbers":[{"type":"mobile","value":"555-0100"}],
+	"emails":[{"primary":"false","value":"old@example.com"},{"primary":"true","value":"mkaz@example.com"}],
+	"ims":[{"type":"skype","value":"mkaz"}],
+	"accounts":[{"domain":"twitter.com","display":"@mkaz","url":"https://twitter.com/mkaz",
+		"username":"mkaz","verified":"true","shortname":"twitter"}],
+	"urls":[{"value":"https://mkaz.blog","title":"Blog"}]}]}`
+
+// newProfileServer serves a fake profile for mkaz and broken responses
+func newProfileServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mkaz.json", "/" + mkazHash + ".json", "/" + HashEmail("mkaz@example.com") + ".json":
+			fmt.Fprint(w, mkazProfile)
+		case "/mkaz.xml":
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><response><entry><hash>`+mkazHash+`</hash></entry></response>`)
+		case "/mkaz.vcf":
+			fmt.Fprint(w, "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Marcus Kazmierczak\r\nEND:VCARD\r\n")
+		case "/mkaz.qr":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprintf(w, "\x89PNG size=%s", r.URL.Query().Get("s"))
+		case "/broken.json":
+			fmt.Fprint(w, `{"entry":`)
+		case "/empty.json":
+			fmt.Fprint(w, `{"entry":[]}`)
+		case "/busy.json":
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		case "/slow.json":
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprint(w, mkazProfile)
+		default:
+			http.Error(w, `"User not found"`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProfileByUsername(t *testing.T) {
+	server := newProfileServer(t)
+	c := NewClient(WithBaseURL(server.URL + "/"))
+
+	gp, err := c.ProfileByUsername(context.Background(), "mkaz")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if gp.Hash != "fc45b574f01cde91f5d1603335ea77c3" || gp.DisplayName != "Marcus Kazmierczak" ||
+		len(gp.Accounts) != 1 || !gp.Accounts[0].Verified {
+		t.Errorf("got profile: %+v", gp)
+	}
+
+	g, err := c.GravatarFromUsername(context.Background(), "mkaz")
+	if err != nil || g.Hash != gp.Hash || g.Host != defaultHostname {
+		t.Errorf("got gravatar: %+v %v", g, err)
+	}
+}
+
+func TestProfileModel(t *testing.T) {
+	server := newProfileServer(t)
+	c := NewClient(WithBaseURL(server.URL))
+
+	gp, err := c.ProfileByEmail(context.Background(), " MKaz@Example.com")
+	if err != nil || gp.Hash != mkazHash {
+		t.Fatalf("got profile by email: %+v %v", gp, err)
+	}
+
+	gp, err = c.ProfileByHash(context.Background(), mkazHash)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	if gp.Name.GivenName != "Marcus" || gp.Name.Formatted != "Marcus Kazmierczak" {
+		t.Errorf("got name: %+v", gp.Name)
+	}
+	if gp.PreferredUsername != "mkaz" || gp.CurrentLocation != "Carlsbad, CA" || gp.ProfileBackground.Url != "" {
+		t.Errorf("got profile: %+v", gp)
+	}
+	if len(gp.Photos) != 1 || gp.Photos[0].Type != "thumbnail" {
+		t.Errorf("got photos: %+v", gp.Photos)
+	}
+	if gp.PrimaryEmail() != "mkaz@example.com" || gp.Emails[0].Primary {
+		t.Errorf("got emails: %+v", gp.Emails)
+	}
+	if len(gp.PhoneNumbers) != 1 || gp.PhoneNumbers[0].Value != "555-0100" ||
+		len(gp.Ims) != 1 || gp.Ims[0].Type != "skype" ||
+		len(gp.Urls) != 1 || gp.Urls[0].Title != "Blog" {
+		t.Errorf("got contacts: %+v %+v %+v", gp.PhoneNumbers, gp.Ims, gp.Urls)
+	}
+	if len(gp.Accounts) != 1 || !gp.Accounts[0].Verified || gp.Accounts[0].Shortname != "twitter" {
+		t.Errorf("got accounts: %+v", gp.Accounts)
+	}
+}
+
+func TestHashEmail(t *testing.T) {
+	if h := HashEmail(" Foo@Example.com \n"); h != "b48def645758b95537d4424c84d1a9ff" {
+		t.Errorf("got hash: %q", h)
+	}
+}
+
+func TestProfileData(t *testing.T) {
+	server := newProfileServer(t)
+	c := NewClient(WithBaseURL(server.URL))
+	ctx := context.Background()
+
+	xml, err := c.ProfileData(ctx, "mkaz", FormatXML)
+	if err != nil || !strings.Contains(string(xml), "<hash>"+mkazHash+"</hash>") {
+		t.Errorf("got xml: %s %v", xml, err)
+	}
+	vcf, err := c.ProfileData(ctx, "mkaz", FormatVCF)
+	if err != nil || !strings.HasPrefix(string(vcf), "BEGIN:VCARD") {
+		t.Errorf("got vcard: %s %v", vcf, err)
+	}
+	qr, err := c.QRCode(ctx, "mkaz", 200)
+	if err != nil || string(qr) != "\x89PNG size=200" {
+		t.Errorf("got qr code: %q %v", qr, err)
+	}
+	if _, err = c.ProfileData(ctx, "nobody", FormatVCF); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("got error: %v; expected: %v", err, ErrProfileNotFound)
+	}
+}
+
+func TestProfileErrors(t *testing.T) {
+	server := newProfileServer(t)
+	c := NewClient(WithBaseURL(server.URL))
+
+	tests := []struct {
+		username string
+		err      error
+	}{
+		{"nobody", ErrProfileNotFound},
+		{"", ErrProfileNotFound},
+		{"broken", ErrInvalidResponse},
+		{"empty", ErrInvalidResponse},
+	}
+	for _, test := range tests {
+		if _, err := c.ProfileByUsername(context.Background(), test.username); !errors.Is(err, test.err) {
+			t.Errorf("got error for %q: %v; expected: %v", test.username, err, test.err)
+		}
+	}
+
+	_, err := c.ProfileByUsername(context.Background(), "busy")
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("got error: %v; expected status error", err)
+	}
+
+	// the zero value of Gravatar is returned when the profile can't be fetched
+	defer func(c Client) { DefaultClient = c }(DefaultClient)
+	DefaultClient = c
+	if g := NewGravatarFromUsername("nobody"); g.Hash != "" {
+		t.Errorf("got hash: %q; expected none", g.Hash)
+	}
+}
+
+func TestProfileTimeout(t *testing.T) {
+	server := newProfileServer(t)
+
+	c := NewClient(WithBaseURL(server.URL), WithHTTPClient(&http.Client{Timeout: 10 * time.Millisecond}))
+	if _, err := c.ProfileByUsername(context.Background(), "slow"); err == nil {
+		t.Errorf("expected timeout error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c = NewClient(WithBaseURL(server.URL))
+	if _, err := c.ProfileByUsername(ctx, "mkaz"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error: %v; expected context.Canceled", err)
+	}
+}
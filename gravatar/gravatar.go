@@ -1,15 +1,12 @@
 package gravatar
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io/ioutil"
-	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -35,24 +32,100 @@ type Account struct {
 	Shortname string `json:"shortname"`
 }
 
+// Name is the real name of the profile owner
+type Name struct {
+	GivenName  string `json:"givenName"`
+	FamilyName string `json:"familyName"`
+	Formatted  string `json:"formatted"`
+}
+
+type Photo struct {
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+type Background struct {
+	Color string `json:"color"`
+	Url   string `json:"url"`
+}
+
+type Email struct {
+	Primary bool   `json:"primary"`
+	Value   string `json:"value"`
+}
+
+type PhoneNumber struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// IM is an instant messaging account, Type is the service such as "aim"
+type IM struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Url is a link listed on the profile
+type Url struct {
+	Value string `json:"value"`
+	Title string `json:"title"`
+}
+
+// GravatarProfile is the public profile of a Gravatar, fields the owner
+// didn't fill in are empty
 type GravatarProfile struct {
-	Hash        string    `json:"hash"`
-	ProfileUrl  string    `json:"profileUrl"`
-	DisplayName string    `json:"displayName"`
-	AboutMe     string    `json:"aboutMe"`
-	Accounts    []Account `json:"accounts"`
+	Id                string        `json:"id"`
+	Hash              string        `json:"hash"`
+	RequestHash       string        `json:"requestHash"`
+	ProfileUrl        string        `json:"profileUrl"`
+	PreferredUsername string        `json:"preferredUsername"`
+	ThumbnailUrl      string        `json:"thumbnailUrl"`
+	Photos            []Photo       `json:"photos"`
+	ProfileBackground Background    `json:"profileBackground"`
+	Name              Name          `json:"name"`
+	DisplayName       string        `json:"displayName"`
+	AboutMe           string        `json:"aboutMe"`
+	CurrentLocation   string        `json:"currentLocation"`
+	Pronouns          string        `json:"pronouns"`
+	PhoneNumbers      []PhoneNumber `json:"phoneNumbers"`
+	Emails            []Email       `json:"emails"`
+	Ims               []IM          `json:"ims"`
+	Accounts          []Account     `json:"accounts"`
+	Urls              []Url         `json:"urls"`
 }
 
 type GravatarResponse struct {
 	Entry []GravatarProfile `json:"entry"`
 }
 
-func NewGravatarFromEmail(email string) Gravatar {
-	hasher := md5.Sum([]byte(email))
-	hash := hex.EncodeToString(hasher[:])
+// PrimaryEmail returns the primary email address, if the owner made it
+// public
+func (gp GravatarProfile) PrimaryEmail() string {
+	for _, e := range gp.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	return ""
+}
+
+// Gravatar returns the avatar of the profile
+func (gp GravatarProfile) Gravatar() Gravatar {
+	g := NewGravatar()
+	g.Hash = gp.Hash
+	return g
+}
+
+// HashEmail returns the Gravatar hash of an email address, which is
+// trimmed and lowercased first
+func HashEmail(email string) string {
+	hasher := md5.Sum([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(hasher[:])
+}
 
+func NewGravatarFromEmail(email string) Gravatar {
 	g := NewGravatar()
-	g.Hash = hash
+	g.Hash = HashEmail(email)
 	return g
 }
 
@@ -103,36 +176,7 @@ func (g Gravatar) GetURL() string {
 	return url.String()
 }
 
+// FetchGravatarProfileByUsername fetches a profile with DefaultClient
 func FetchGravatarProfileByUsername(username string) (gp GravatarProfile, err error) {
-	url := fmt.Sprintf("https://en.gravatar.com/%s.json", username)
-	response, err := http.Get(url)
-	defer response.Body.Close()
-
-	if response.StatusCode == http.StatusNotFound {
-		return gp, errors.New("Username not found, no Gravatar data")
-	}
-
-	if response.StatusCode != http.StatusOK {
-		return gp, errors.New("Error fetching Gravatar data")
-	}
-
-	// slurp the entire body of the response
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return gp, err
-	}
-
-	// decode body to GravatarProfile
-	var gr GravatarResponse
-	if json.Unmarshal(body, &gr); err != nil {
-		return gp, err
-	}
-
-	if len(gr.Entry) != 1 {
-		return gp, errors.New("Invalid Gravatar Response")
-	}
-
-	gp = gr.Entry[0]
-
-	return gp, err
+	return DefaultClient.ProfileByUsername(context.Background(), username)
 }
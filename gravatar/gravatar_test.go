@@ -11,6 +11,13 @@ func TestNewGravatarFromEmail(t *testing.T) {
 	if g.Hash != emailHashed {
 		t.Errorf("got hash: %q; expected: %q", g.Hash, emailHashed)
 	}
+
+	// emails are normalized before hashing
+	for _, email := range []string{"Foo@Example.com", "  foo@example.com\n", "FOO@EXAMPLE.COM "} {
+		if g := NewGravatarFromEmail(email); g.Hash != emailHashed {
+			t.Errorf("got hash for %q: %q; expected: %q", email, g.Hash, emailHashed)
+		}
+	}
 }
 
 func TestGravatarGetURL(t *testing.T) {
@@ -43,6 +50,10 @@ func TestGravatarGetURL(t *testing.T) {
 }
 
 func TestNewGravatarFromUsername(t *testing.T) {
+	server := newProfileServer(t)
+	defer func(c Client) { DefaultClient = c }(DefaultClient)
+	DefaultClient = NewClient(WithBaseURL(server.URL))
+
 	username := "mkaz"
 	expectedHash := "fc45b574f01cde91f5d1603335ea77c3"
 	g := NewGravatarFromUsername(username)
@@ -0,0 +1,78 @@
+package gravatar
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// The profile API writes booleans as "true" and "false" strings and
+// objects left empty as [], these decode either form.
+
+// jsonBool decodes a boolean or a quoted boolean
+type jsonBool bool
+
+func (b *jsonBool) UnmarshalJSON(data []byte) error {
+	s, err := strconv.Unquote(string(data))
+	if err != nil {
+		s = string(data)
+	}
+	if s == "" || s == "null" {
+		*b = false
+		return nil
+	}
+	v, err := strconv.ParseBool(s)
+	*b = jsonBool(v)
+	return err
+}
+
+// emptyArray reports whether data is a JSON array, as sent for empty objects
+func emptyArray(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("["))
+}
+
+func (a *Account) UnmarshalJSON(data []byte) error {
+	type account Account
+	var v struct {
+		account
+		Verified jsonBool `json:"verified"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*a = Account(v.account)
+	a.Verified = bool(v.Verified)
+	return nil
+}
+
+func (e *Email) UnmarshalJSON(data []byte) error {
+	type email Email
+	var v struct {
+		email
+		Primary jsonBool `json:"primary"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = Email(v.email)
+	e.Primary = bool(v.Primary)
+	return nil
+}
+
+func (n *Name) UnmarshalJSON(data []byte) error {
+	if emptyArray(data) {
+		*n = Name{}
+		return nil
+	}
+	type name Name
+	return json.Unmarshal(data, (*name)(n))
+}
+
+func (b *Background) UnmarshalJSON(data []byte) error {
+	if emptyArray(data) {
+		*b = Background{}
+		return nil
+	}
+	type background Background
+	return json.Unmarshal(data, (*background)(b))
+}
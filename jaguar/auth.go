@@ -0,0 +1,341 @@
+// auth.go - authentication helpers for jaguar
+//
+// Authenticators add credentials to outgoing requests: static bearer and
+// basic auth, an OAuth2 token source which fetches and refreshes tokens,
+// and an HMAC request signer.
+
+package jaguar
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator adds credentials to a request before it is sent
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// AuthFunc adapts a function to the Authenticator interface
+type AuthFunc func(req *http.Request) error
+
+func (f AuthFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// BearerAuth sends a static bearer token
+func BearerAuth(token string) Authenticator {
+	return AuthFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// BasicAuth sends the username and password using HTTP basic auth
+func BasicAuth(username, password string) Authenticator {
+	return AuthFunc(func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// BasicTokenAuth sends an already encoded base64("username:password") token
+func BasicTokenAuth(token string) Authenticator {
+	return AuthFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Basic "+token)
+		return nil
+	})
+}
+
+// Token is an OAuth2 access token
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"-"`
+}
+
+// OAuth2Error is the error response from a token endpoint
+type OAuth2Error struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuth2Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("jaguar: oauth2 %s: %s", e.Code, e.Description)
+	}
+	return fmt.Sprintf("jaguar: oauth2 %s (status %d)", e.Code, e.StatusCode)
+}
+
+var ErrNoAccessToken = errors.New("jaguar: token response has no access_token")
+
+// DefaultTokenTimeout limits token requests when OAuth2.Timeout is not set
+const DefaultTokenTimeout = 30 * time.Second
+
+// authRequestKey is the context key of the request being authenticated,
+// token requests reuse its TLS, proxy and transport settings
+type authRequestKey struct{}
+
+// OAuth2 is a token source for the client credentials and refresh token
+// grants. Tokens are cached and refreshed shortly before they expire, it
+// is safe to share between goroutines. Requests waiting for a token give
+// up when their context is done, the token request uses the TLS, proxy and
+// transport settings of the request it authenticates.
+type OAuth2 struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// RefreshToken switches to the refresh_token grant, it is updated when
+	// the server rotates it
+	RefreshToken string
+
+	// ExpiryDelta refreshes tokens this long before they expire,
+	// defaults to 10 seconds
+	ExpiryDelta time.Duration
+
+	// Timeout limits each token request, defaults to DefaultTokenTimeout
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	token *Token
+
+	// fetching is closed when the token request in flight finishes
+	fetching chan struct{}
+}
+
+// Token returns a valid access token, fetching a new one if needed
+func (o *OAuth2) Token() (Token, error) {
+	return o.TokenContext(context.Background())
+}
+
+// TokenContext returns a valid access token, fetching a new one if needed.
+// Only one token request is made at a time, the others wait for it until
+// ctx is done.
+func (o *OAuth2) TokenContext(ctx context.Context) (Token, error) {
+	for {
+		o.mu.Lock()
+		if o.token != nil && o.valid(*o.token) {
+			token := *o.token
+			o.mu.Unlock()
+			return token, nil
+		}
+
+		if fetching := o.fetching; fetching != nil {
+			o.mu.Unlock()
+			select {
+			case <-fetching:
+				// check the new token, or fetch again if it failed
+				continue
+			case <-ctx.Done():
+				return Token{}, ctx.Err()
+			}
+		}
+
+		refresh := o.RefreshToken
+		if o.token != nil && o.token.RefreshToken != "" {
+			refresh = o.token.RefreshToken
+		}
+		fetching := make(chan struct{})
+		o.fetching = fetching
+		o.mu.Unlock()
+
+		token, err := o.fetch(ctx, refresh)
+
+		o.mu.Lock()
+		o.fetching = nil
+		close(fetching)
+		if err == nil {
+			if token.RefreshToken == "" {
+				token.RefreshToken = refresh
+			}
+			o.token = &token
+		}
+		o.mu.Unlock()
+		return token, err
+	}
+}
+
+// Invalidate drops the cached access token, the next request fetches a
+// new one
+func (o *OAuth2) Invalidate() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.token != nil {
+		o.RefreshToken = o.token.RefreshToken
+	}
+	o.token = nil
+}
+
+func (o *OAuth2) Authenticate(req *http.Request) error {
+	token, err := o.TokenContext(req.Context())
+	if err != nil {
+		return err
+	}
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	req.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	return nil
+}
+
+func (o *OAuth2) valid(t Token) bool {
+	if t.AccessToken == "" {
+		return false
+	}
+	if t.Expiry.IsZero() {
+		return true
+	}
+	delta := o.ExpiryDelta
+	if delta == 0 {
+		delta = 10 * time.Second
+	}
+	return time.Now().Add(delta).Before(t.Expiry)
+}
+
+func (o *OAuth2) fetch(ctx context.Context, refresh string) (token Token, err error) {
+	j := New()
+	if r, ok := ctx.Value(authRequestKey{}).(*Jaguar); ok {
+		j.VerifyCert = r.VerifyCert
+		j.TLS = r.TLS
+		j.Proxy = r.Proxy
+		j.Dial = r.Dial
+		j.Transport = r.Transport
+	}
+	j.Context = ctx
+	j.Timeout = o.Timeout
+	if j.Timeout <= 0 {
+		j.Timeout = DefaultTokenTimeout
+	}
+	if refresh != "" {
+		j.Params.Set("grant_type", "refresh_token")
+		j.Params.Set("refresh_token", refresh)
+	} else {
+		j.Params.Set("grant_type", "client_credentials")
+	}
+	if len(o.Scopes) > 0 {
+		j.Params.Set("scope", strings.Join(o.Scopes, " "))
+	}
+	j.Header.Set("Accept", "application/json")
+	j.WithAuth(BasicAuth(o.ClientID, o.ClientSecret))
+
+	resp, err := j.Post(o.TokenURL).Send()
+	if err != nil {
+		return token, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		oerr := &OAuth2Error{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(resp.Bytes, oerr)
+		if oerr.Code == "" {
+			oerr.Code = "invalid_response"
+		}
+		return token, oerr
+	}
+
+	var tr struct {
+		Token
+		ExpiresIn int64 `json:"expires_in"`
+	}
+	if err = json.Unmarshal(resp.Bytes, &tr); err != nil {
+		return token, err
+	}
+	if tr.AccessToken == "" {
+		return token, ErrNoAccessToken
+	}
+
+	token = tr.Token
+	if tr.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// HMACSigner signs requests with a shared secret. The signature covers the
+// method, path and query, Date header and a SHA-256 digest of the body:
+//
+//	METHOD\nPATH?QUERY\nDATE\nHEX(SHA256(BODY))
+//
+// and is sent as
+//
+//	Authorization: HMAC-SHA256 keyId="id",signature="base64"
+//
+// with the name of Hash in the scheme, such as HMAC-SHA512.
+type HMACSigner struct {
+	KeyID  string
+	Secret []byte
+
+	// Hash of the HMAC, defaults to crypto.SHA256, its package must be
+	// linked in such as with import _ "crypto/sha512"
+	Hash crypto.Hash
+}
+
+// ErrHashUnavailable is returned when HMACSigner.Hash isn't linked in
+var ErrHashUnavailable = errors.New("jaguar: hmac hash function unavailable")
+
+func (s HMACSigner) Authenticate(req *http.Request) error {
+	if !s.hash().Available() {
+		return ErrHashUnavailable
+	}
+
+	var body []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		req.Body.Close()
+		body = b
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if req.Header.Get("Date") == "" {
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+
+	digest := sha256.Sum256(body)
+	req.Header.Set("X-Content-Sha256", hex.EncodeToString(digest[:]))
+
+	signature := s.Sign(req.Method, req.URL.RequestURI(), req.Header.Get("Date"), body)
+	req.Header.Set("Authorization", fmt.Sprintf(`%s keyId="%s",signature="%s"`, s.Scheme(), s.KeyID, signature))
+	return nil
+}
+
+// Scheme returns the Authorization scheme, HMAC- and the hash name such as
+// HMAC-SHA256
+func (s HMACSigner) Scheme() string {
+	return "HMAC-" + strings.Replace(s.hash().String(), "-", "", -1)
+}
+
+func (s HMACSigner) hash() crypto.Hash {
+	if s.Hash == 0 {
+		return crypto.SHA256
+	}
+	return s.Hash
+}
+
+// Sign returns the base64 signature for the given request parts, servers
+// can use it to verify incoming requests
+func (s HMACSigner) Sign(method, requestURI, date string, body []byte) string {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(s.hash().New, s.Secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, requestURI, date, hex.EncodeToString(digest[:]))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,257 @@
+package jaguar_test
+
+import (
+	"context"
+	"crypto"
+	_ "crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/automattic/go/jaguar"
+)
+
+// This tests static bearer and basic authenticators
+func TestStaticAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		auth     jaguar.Authenticator
+		expected string
+	}{
+		{jaguar.BearerAuth("secret"), "Bearer secret"},
+		{jaguar.BasicAuth("user", "pass"), "Basic dXNlcjpwYXNz"},
+		{jaguar.BasicTokenAuth("dXNlcjpwYXNz"), "Basic dXNlcjpwYXNz"},
+	}
+
+	for _, test := range tests {
+		j := jaguar.New()
+		resp, err := j.WithAuth(test.auth).Get(ts.URL).Send()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if resp.String() != test.expected {
+			t.Errorf("got header: %q; expected: %q", resp.String(), test.expected)
+		}
+	}
+}
+
+// oauthServer is a minimal token endpoint which counts grants
+type oauthServer struct {
+	mu     sync.Mutex
+	grants []string
+	issued int
+}
+
+func (s *oauthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != "client" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"invalid_client","error_description":"bad credentials"}`)
+		return
+	}
+
+	grant := r.FormValue("grant_type")
+	if grant == "refresh_token" && r.FormValue("refresh_token") != fmt.Sprintf("refresh-%d", s.issued) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_grant"}`)
+		return
+	}
+	s.grants = append(s.grants, grant)
+	s.issued++
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  fmt.Sprintf("access-%d", s.issued),
+		"token_type":    "bearer",
+		"expires_in":    3600,
+		"refresh_token": fmt.Sprintf("refresh-%d", s.issued),
+	})
+}
+
+// This tests tokens are cached and refreshed with the refresh_token grant
+func TestOAuth2TokenRefresh(t *testing.T) {
+	srv := &oauthServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	o := &jaguar.OAuth2{TokenURL: ts.URL, ClientID: "client", ClientSecret: "secret"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := o.Token()
+			if err != nil || token.AccessToken != "access-1" {
+				t.Errorf("Unexpected token: %v %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	o.Invalidate()
+	token, err := o.Token()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if token.AccessToken != "access-2" {
+		t.Errorf("Expected refreshed token, got %v", token.AccessToken)
+	}
+
+	// tokens inside the expiry delta are refreshed before use
+	o.ExpiryDelta = 2 * time.Hour
+	token, _ = o.Token()
+	if token.AccessToken != "access-3" {
+		t.Errorf("Expected refreshed token, got %v", token.AccessToken)
+	}
+
+	expected := []string{"client_credentials", "refresh_token", "refresh_token"}
+	if strings.Join(srv.grants, ",") != strings.Join(expected, ",") {
+		t.Errorf("got grants: %v; expected: %v", srv.grants, expected)
+	}
+}
+
+// This tests token endpoint errors are returned as OAuth2Error
+func TestOAuth2Error(t *testing.T) {
+	ts := httptest.NewServer(&oauthServer{})
+	defer ts.Close()
+
+	o := &jaguar.OAuth2{TokenURL: ts.URL, ClientID: "client", ClientSecret: "wrong"}
+	j := jaguar.New()
+	_, err := j.WithAuth(o).Get(ts.URL).Send()
+
+	oerr, ok := err.(*jaguar.OAuth2Error)
+	if !ok {
+		t.Fatalf("Expected OAuth2Error, got %v", err)
+	}
+	if oerr.Code != "invalid_client" || oerr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unexpected error: %+v", oerr)
+	}
+}
+
+// This tests a hung token endpoint doesn't block requests past their
+// deadline, and the token request has a timeout
+func TestOAuth2Hung(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	o := &jaguar.OAuth2{TokenURL: ts.URL, ClientID: "client", ClientSecret: "secret", Timeout: 500 * time.Millisecond}
+
+	fetched := make(chan error)
+	go func() {
+		_, err := o.Token()
+		fetched <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	j := jaguar.New()
+	_, err := j.WithAuth(o).WithContext(ctx).Get(ts.URL).Send()
+	if err != context.DeadlineExceeded || time.Since(start) > 400*time.Millisecond {
+		t.Errorf("Expected waiting request to stop at its deadline, got %v after %v", err, time.Since(start))
+	}
+
+	select {
+	case err = <-fetched:
+		if err == nil {
+			t.Errorf("Expected token request to time out")
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected token request to time out")
+	}
+}
+
+// authTransport counts the requests it sends
+type authTransport struct {
+	mu   sync.Mutex
+	urls []string
+}
+
+func (t *authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.urls = append(t.urls, r.URL.Path)
+	t.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// This tests the token request uses the transport of the request
+func TestOAuth2Transport(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/token", &oauthServer{})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	o := &jaguar.OAuth2{TokenURL: ts.URL + "/token", ClientID: "client", ClientSecret: "secret"}
+	tr := &authTransport{}
+	j := jaguar.New()
+	resp, err := j.WithAuth(o).WithTransport(tr).Get(ts.URL + "/api").Send()
+	if err != nil || resp.String() != "Bearer access-1" {
+		t.Fatalf("Unexpected response: %v %v", resp.String(), err)
+	}
+	if strings.Join(tr.urls, ",") != "/token,/api" {
+		t.Errorf("Expected token request through the transport, got %v", tr.urls)
+	}
+}
+
+// This tests the HMAC signature can be verified by the server
+func TestHMACSigner(t *testing.T) {
+	tests := []struct {
+		signer jaguar.HMACSigner
+		scheme string
+	}{
+		{jaguar.HMACSigner{KeyID: "key", Secret: []byte("shh")}, "HMAC-SHA256"},
+		{jaguar.HMACSigner{KeyID: "key", Secret: []byte("shh"), Hash: crypto.SHA512}, "HMAC-SHA512"},
+	}
+
+	for _, test := range tests {
+		signer := test.signer
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			expected := fmt.Sprintf(`%s keyId="key",signature="%s"`, test.scheme,
+				signer.Sign(r.Method, r.URL.RequestURI(), r.Header.Get("Date"), body))
+			if r.Header.Get("Authorization") != expected {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+			fmt.Fprint(w, string(body))
+		}))
+
+		j := jaguar.New()
+		j.Params.Add("p", "hello")
+		resp, err := j.WithAuth(signer).Post(ts.URL + "/path?q=1").Send()
+		ts.Close()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.String() != "p=hello" {
+			t.Errorf("Unexpected result for %s: %v %v", test.scheme, resp.StatusCode, resp.String())
+		}
+	}
+
+	// hashes not linked in are refused
+	j := jaguar.New()
+	_, err := j.WithAuth(jaguar.HMACSigner{Hash: crypto.MD4}).Get("http://127.0.0.1/").Send()
+	if err != jaguar.ErrHashUnavailable {
+		t.Errorf("Expected ErrHashUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+// batch.go - concurrent execution of many jaguar requests
+//
+// A Batch sends prepared requests with a bounded number of workers and
+// returns the results in the same order as the requests.
+
+package jaguar
+
+import (
+	"context"
+	"net/url"
+	"sync"
+)
+
+// DefaultBatchWorkers is the number of concurrent requests when
+// Batch.Workers is not set
+const DefaultBatchWorkers = 10
+
+// Batch sends many independent requests concurrently
+type Batch struct {
+	Requests []*Jaguar
+
+	// Workers is the maximum number of requests in flight
+	Workers int
+
+	// FailFast stops sending requests after the first error, requests not
+	// yet finished get the context error. Otherwise every request is sent
+	// and errors are only reported in the results.
+	FailFast bool
+}
+
+// BatchResult is the outcome of one request in a batch
+type BatchResult struct {
+	Response Response
+	Err      error
+}
+
+// Add appends requests to the batch
+func (b *Batch) Add(requests ...*Jaguar) *Batch {
+	b.Requests = append(b.Requests, requests...)
+	return b
+}
+
+// Send executes the requests and returns one result per request, in the
+// same order. The error is the first request error when FailFast is set,
+// or the context error if ctx was cancelled before all requests finished.
+func (b *Batch) Send(ctx context.Context) ([]BatchResult, error) {
+	results := make([]BatchResult, len(b.Requests))
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	workers := b.Workers
+	if workers <= 0 {
+		workers = DefaultBatchWorkers
+	}
+	if workers > len(b.Requests) {
+		workers = len(b.Requests)
+	}
+
+	var once sync.Once
+	var firstErr error
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+
+				// copy so the same request can be added more than once
+				j := b.Requests[i].copy()
+				var stop func()
+				j.Context, stop = requestContext(ctx, j.Context)
+				results[i].Response, results[i].Err = j.Send()
+				stop()
+
+				if results[i].Err != nil && b.FailFast {
+					once.Do(func() {
+						firstErr = results[i].Err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	for i := range b.Requests {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return results, firstErr
+	}
+	return results, parent.Err()
+}
+
+// copy returns a copy of the request with its own header, params and file
+// maps, so copies can be built concurrently
+func (j *Jaguar) copy() *Jaguar {
+	c := *j
+	c.Header = cloneHeader(j.Header)
+	c.Params = url.Values{}
+	for k, v := range j.Params {
+		c.Params[k] = append([]string(nil), v...)
+	}
+	c.Files = map[string]string{}
+	for k, v := range j.Files {
+		c.Files[k] = v
+	}
+	c.FileReaders = map[string]FileReader{}
+	for k, v := range j.FileReaders {
+		c.FileReaders[k] = v
+	}
+	return &c
+}
+
+// requestContext returns the batch context for requests without their
+// own, otherwise the request context also cancelled with the batch, so the
+// request keeps its deadline and values
+func requestContext(batch, ctx context.Context) (context.Context, func()) {
+	if ctx == nil {
+		return batch, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(batch, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
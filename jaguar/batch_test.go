@@ -0,0 +1,205 @@
+package jaguar_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/automattic/go/jaguar"
+)
+
+// This tests results come back in order with a bounded number of workers
+func TestBatchOrder(t *testing.T) {
+	var inflight, peak int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inflight, -1)
+		if r.URL.Path == "/missing.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Header().Set("X-Path", r.URL.Path)
+	}))
+	defer ts.Close()
+
+	var b jaguar.Batch
+	b.Workers = 3
+	for i := 0; i < 20; i++ {
+		j := jaguar.New()
+		b.Add(j.Head(fmt.Sprintf("%s/%d.jpg", ts.URL, i)))
+	}
+	j := jaguar.New()
+	b.Add(j.Head(ts.URL + "/missing.jpg"))
+
+	results, err := b.Send(context.Background())
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	for i, r := range results[:20] {
+		if r.Err != nil || r.Response.Header.Get("X-Path") != fmt.Sprintf("/%d.jpg", i) {
+			t.Errorf("Unexpected result %d: %v %v", i, r.Response.Header.Get("X-Path"), r.Err)
+		}
+	}
+	if results[20].Response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing image, got %v", results[20].Response.StatusCode)
+	}
+	if peak > 3 {
+		t.Errorf("Expected at most 3 concurrent requests, got %d", peak)
+	}
+}
+
+// This tests fail fast stops after the first error, collect all doesn't
+func TestBatchFailFast(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	requests := func() []*jaguar.Jaguar {
+		var requests []*jaguar.Jaguar
+		bad := jaguar.New()
+		requests = append(requests, bad.Method("BREW").Url(ts.URL))
+		for i := 0; i < 10; i++ {
+			j := jaguar.New()
+			requests = append(requests, j.Get(ts.URL))
+		}
+		return requests
+	}
+
+	b := jaguar.Batch{Requests: requests(), Workers: 1, FailFast: true}
+	results, err := b.Send(context.Background())
+	if err == nil || results[0].Err == nil {
+		t.Errorf("Expected first error to be returned")
+	}
+	if hits != 0 {
+		t.Errorf("Expected no requests after failure, got %d", hits)
+	}
+	for _, r := range results[1:] {
+		if r.Err != context.Canceled {
+			t.Errorf("Expected skipped requests to be cancelled, got %v", r.Err)
+		}
+	}
+
+	b = jaguar.Batch{Requests: requests(), Workers: 4}
+	results, err = b.Send(context.Background())
+	if err != nil || results[0].Err == nil {
+		t.Errorf("Expected error only in results: %v", err)
+	}
+	if hits != 10 {
+		t.Errorf("Expected all requests to be sent, got %d", hits)
+	}
+}
+
+// This tests the same request can be added twice and requests keep their
+// own context
+func TestBatchSharedRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"), " ", r.URL.RawQuery)
+	}))
+	defer ts.Close()
+
+	auth := jaguar.AuthFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer token")
+		return nil
+	})
+	j := jaguar.New()
+	j.Params.Add("q", "golang")
+	j.WithAuth(auth).Get(ts.URL)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	other := jaguar.New()
+	other.WithContext(cancelled).Get(ts.URL)
+
+	var b jaguar.Batch
+	b.Workers = 4
+	for i := 0; i < 10; i++ {
+		b.Add(&j)
+	}
+	b.Add(&other)
+
+	results, err := b.Send(context.Background())
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	for i, r := range results[:10] {
+		if r.Err != nil || r.Response.String() != "Bearer token q=golang" {
+			t.Errorf("Unexpected result %d: %q %v", i, r.Response.String(), r.Err)
+		}
+	}
+	if !errors.Is(results[10].Err, context.Canceled) {
+		t.Errorf("Expected request context to be kept, got %v", results[10].Err)
+	}
+	if len(j.Header) != 0 || len(j.Params) != 1 {
+		t.Errorf("Expected request to be unchanged: %v %v", j.Header, j.Params)
+	}
+}
+
+// This tests cancelling the context stops the batch
+func TestBatchCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	var b jaguar.Batch
+	b.Workers = 2
+	for i := 0; i < 20; i++ {
+		j := jaguar.New()
+		b.Add(j.Get(ts.URL))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 75*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := b.Send(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("Expected batch to stop early, took %v", elapsed)
+	}
+}
+
+// This tests connections aren't left open once a batch is sent
+func TestBatchConnections(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	before := runtime.NumGoroutine()
+
+	var b jaguar.Batch
+	for i := 0; i < 200; i++ {
+		j := jaguar.New()
+		b.Add(j.Head(fmt.Sprintf("%s/%d.jpg", ts.URL, i)))
+	}
+	if _, err := b.Send(context.Background()); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	// connection goroutines exit shortly after the connections close
+	var after int
+	for i := 0; i < 50; i++ {
+		if after = runtime.NumGoroutine(); after <= before+5 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("Expected connections to be closed, goroutines went from %d to %d", before, after)
+}
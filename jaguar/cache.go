@@ -0,0 +1,513 @@
+// cache.go - client-side HTTP caching for jaguar
+//
+// A small RFC 9111 style private cache. Fresh responses are served without
+// touching the network, stale responses carrying an ETag or Last-Modified
+// are revalidated with a conditional request, and a 304 from the server
+// is turned back into the cached response. Responses to requests with an
+// Authorization or Cookie header are only stored when marked public, as the
+// store may be shared between users.
+
+package jaguar
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheHeader is set on responses served by the cache, with one of the
+// values "hit", "stale" or "revalidated"
+const CacheHeader = "X-Jaguar-Cache"
+
+// CacheEntry is a stored response along with the time it was received
+type CacheEntry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Stored     time.Time
+
+	// request header values named by the response Vary header
+	Vary map[string]string
+}
+
+// CacheStore is the pluggable storage backing a cache
+type CacheStore interface {
+	Get(key string) (entry CacheEntry, ok bool)
+	Set(key string, entry CacheEntry)
+	Delete(key string)
+}
+
+// MemoryCache is an in-memory least recently used CacheStore
+type MemoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry CacheEntry
+}
+
+// NewMemoryCache creates an LRU cache holding at most maxEntries
+// responses, zero means no limit
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+func (c *MemoryCache) Get(key string) (entry CacheEntry, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return entry, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*memoryCacheItem).entry, true
+}
+
+func (c *MemoryCache) Set(key string, entry CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*memoryCacheItem).entry = entry
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&memoryCacheItem{key, entry})
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+// Len returns the number of stored responses
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Cacher is a gob encoding key/value cache, such as acache.Cache
+type Cacher interface {
+	Get(key string, v interface{}) error
+	Set(key string, data interface{}) error
+	Delete(key string) error
+}
+
+type cacherStore struct {
+	cacher Cacher
+	prefix string
+}
+
+// NewCacherStore returns a CacheStore backed by a Cacher, keys are
+// prefixed with "jaguar:" to share the cache with other data
+func NewCacherStore(c Cacher) CacheStore {
+	return cacherStore{cacher: c, prefix: "jaguar:"}
+}
+
+func (s cacherStore) Get(key string) (entry CacheEntry, ok bool) {
+	if err := s.cacher.Get(s.prefix+key, &entry); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (s cacherStore) Set(key string, entry CacheEntry) {
+	_ = s.cacher.Set(s.prefix+key, entry)
+}
+
+func (s cacherStore) Delete(key string) {
+	_ = s.cacher.Delete(s.prefix + key)
+}
+
+// RevalidateTimeout limits background revalidations of stale responses
+const RevalidateTimeout = 30 * time.Second
+
+// revalidating holds the background revalidations in flight, jaguar builds
+// a transport for each request so they are tracked here
+var revalidating = struct {
+	sync.Mutex
+	keys map[revalidation]bool
+}{keys: map[revalidation]bool{}}
+
+type revalidation struct {
+	store CacheStore
+	key   string
+}
+
+// CacheTransport is a http.RoundTripper caching responses in Store
+type CacheTransport struct {
+	Store     CacheStore
+	Transport http.RoundTripper
+
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// NewCacheTransport creates a caching round tripper on top of the default
+// http transport
+func NewCacheTransport(store CacheStore) *CacheTransport {
+	return &CacheTransport{Store: store, Transport: http.DefaultTransport}
+}
+
+func (t *CacheTransport) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+func (t *CacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := cacheKey(req)
+
+	switch req.Method {
+	case "GET":
+	case "HEAD", "OPTIONS", "TRACE":
+		return t.Transport.RoundTrip(req)
+	default:
+		// unsafe methods invalidate what we know about the url
+		resp, err := t.Transport.RoundTrip(req)
+		if err == nil && resp.StatusCode < 400 {
+			t.Store.Delete(key)
+		}
+		return resp, err
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return t.Transport.RoundTrip(req)
+	}
+
+	entry, ok := t.Store.Get(key)
+	if ok && !entry.matchesVary(req) {
+		ok = false
+	}
+	if !ok {
+		return t.fetch(req, key)
+	}
+
+	respCC := parseCacheControl(entry.Header)
+	age := entry.age(t.clock())
+	lifetime := entry.freshness(respCC)
+
+	_, reqNoCache := reqCC["no-cache"]
+	_, respNoCache := respCC["no-cache"]
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age >= maxAge {
+		reqNoCache = true
+	}
+
+	if !reqNoCache && !respNoCache && age < lifetime {
+		return entry.response(req, "hit", age), nil
+	}
+
+	// serve stale while revalidating in the background
+	if swr, ok := respCC.seconds("stale-while-revalidate"); ok && !reqNoCache && !respNoCache && age < lifetime+swr {
+		t.revalidateBackground(req, key, entry)
+		return entry.response(req, "stale", age), nil
+	}
+
+	resp, err := t.revalidate(req, key, entry)
+	if err != nil || resp.StatusCode >= 500 {
+		// stale-if-error allows the stored response to cover outages
+		if sie, ok := respCC.seconds("stale-if-error"); ok && age < lifetime+sie {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return entry.response(req, "stale", age), nil
+		}
+	}
+	return resp, err
+}
+
+// revalidateBackground revalidates an entry after the response is served,
+// unless the key is already being revalidated. The request keeps its
+// context values but not its cancellation, and is limited to
+// RevalidateTimeout.
+func (t *CacheTransport) revalidateBackground(req *http.Request, key string, entry CacheEntry) {
+	r := revalidation{key: key}
+	if reflect.ValueOf(t.Store).Comparable() {
+		r.store = t.Store
+	}
+
+	revalidating.Lock()
+	if revalidating.keys[r] {
+		revalidating.Unlock()
+		return
+	}
+	revalidating.keys[r] = true
+	revalidating.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), RevalidateTimeout)
+	req = req.Clone(ctx)
+	go func() {
+		defer func() {
+			cancel()
+			revalidating.Lock()
+			delete(revalidating.keys, r)
+			revalidating.Unlock()
+		}()
+		resp, err := t.revalidate(req, key, entry)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+}
+
+// revalidate sends a conditional request for a stored entry
+func (t *CacheTransport) revalidate(req *http.Request, key string, entry CacheEntry) (*http.Response, error) {
+	etag := entry.Header.Get("ETag")
+	lastModified := entry.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return t.fetch(req, key)
+	}
+
+	req = req.Clone(req.Context())
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusNotModified {
+		return t.store(req, key, resp)
+	}
+	resp.Body.Close()
+
+	// merge updated headers from the 304 into the stored response
+	entry.Header = cloneHeader(entry.Header)
+	for k, v := range resp.Header {
+		entry.Header[k] = v
+	}
+	entry.Stored = t.clock()
+	t.Store.Set(key, entry)
+
+	return entry.response(req, "revalidated", 0), nil
+}
+
+func (t *CacheTransport) fetch(req *http.Request, key string) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.store(req, key, resp)
+}
+
+// store saves a response when it is cacheable, the body is read into
+// memory and replaced so the caller can still consume it
+func (t *CacheTransport) store(req *http.Request, key string, resp *http.Response) (*http.Response, error) {
+	if !isCacheable(resp) || (hasCredentials(req) && !isPublic(resp)) {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	entry := CacheEntry{
+		StatusCode: resp.StatusCode,
+		Header:     cloneHeader(resp.Header),
+		Body:       body,
+		Stored:     t.clock(),
+	}
+	for _, name := range headerTokens(resp.Header, "Vary") {
+		if entry.Vary == nil {
+			entry.Vary = map[string]string{}
+		}
+		entry.Vary[http.CanonicalHeaderKey(name)] = req.Header.Get(name)
+	}
+	t.Store.Set(key, entry)
+
+	return resp, nil
+}
+
+func cacheKey(req *http.Request) string {
+	return "GET " + req.URL.String()
+}
+
+// isCacheable reports whether a response may be stored by a private cache
+func isCacheable(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case 200, 203, 300, 301, 404, 410:
+	default:
+		return false
+	}
+
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	for _, v := range headerTokens(resp.Header, "Vary") {
+		if v == "*" {
+			return false
+		}
+	}
+
+	// need either explicit freshness or a validator to be useful
+	_, maxAge := cc["max-age"]
+	return maxAge ||
+		resp.Header.Get("Expires") != "" ||
+		resp.Header.Get("ETag") != "" ||
+		resp.Header.Get("Last-Modified") != ""
+}
+
+// hasCredentials reports whether the request identifies a user, the key
+// only holds the url so responses to it could be served to anyone
+func hasCredentials(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+}
+
+// isPublic reports whether a response to a request with credentials may be
+// stored and shared, see RFC 9111 section 3.5
+func isPublic(resp *http.Response) bool {
+	cc := parseCacheControl(resp.Header)
+	_, public := cc["public"]
+	_, sMaxAge := cc["s-maxage"]
+	return public || sMaxAge
+}
+
+func (e CacheEntry) matchesVary(req *http.Request) bool {
+	for name, value := range e.Vary {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// age of the stored response, including any Age reported upstream
+func (e CacheEntry) age(now time.Time) time.Duration {
+	age := now.Sub(e.Stored)
+	if s, err := strconv.Atoi(e.Header.Get("Age")); err == nil && s > 0 {
+		age += time.Duration(s) * time.Second
+	}
+	return age
+}
+
+// freshness lifetime from max-age, Expires or the Last-Modified heuristic
+func (e CacheEntry) freshness(cc cacheControl) time.Duration {
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		return maxAge
+	}
+
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.Stored
+	}
+
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return t.Sub(date)
+	}
+
+	if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && date.After(lm) {
+		return date.Sub(lm) / 10
+	}
+
+	return 0
+}
+
+// response rebuilds a http.Response from the entry
+func (e CacheEntry) response(req *http.Request, status string, age time.Duration) *http.Response {
+	header := cloneHeader(e.Header)
+	header.Set(CacheHeader, status)
+	if age > 0 {
+		header.Set("Age", strconv.Itoa(int(age.Seconds())))
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, part := range headerTokens(h, "Cache-Control") {
+		name, value := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, value = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		cc[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+	return cc
+}
+
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	s, err := strconv.Atoi(v)
+	if err != nil || s < 0 {
+		return 0, false
+	}
+	return time.Duration(s) * time.Second, true
+}
+
+// headerTokens splits comma separated header values
+func headerTokens(h http.Header, name string) (tokens []string) {
+	for _, line := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(line, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return tokens
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
@@ -0,0 +1,242 @@
+package jaguar_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/automattic/go/jaguar"
+)
+
+// This tests a fresh response is served without another request
+func TestCacheMaxAge(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "hola mundo")
+	}))
+	defer ts.Close()
+
+	cache := jaguar.NewMemoryCache(10)
+	for i := 0; i < 3; i++ {
+		j := jaguar.New()
+		resp, err := j.WithCache(cache).Get(ts.URL).Send()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if resp.String() != "hola mundo" {
+			t.Errorf("Unexpected result: %v", resp.String())
+		}
+		if i > 0 && resp.Header.Get(jaguar.CacheHeader) != "hit" {
+			t.Errorf("Expected cache hit on request %d", i)
+		}
+	}
+
+	if hits != 1 {
+		t.Errorf("Expected 1 request to server, got %d", hits)
+	}
+}
+
+// This tests stale responses are revalidated using the ETag
+func TestCacheETagRevalidate(t *testing.T) {
+	var hits, notModified int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, "hola mundo")
+	}))
+	defer ts.Close()
+
+	cache := jaguar.NewMemoryCache(10)
+	for i := 0; i < 2; i++ {
+		j := jaguar.New()
+		resp, err := j.WithCache(cache).Get(ts.URL).Send()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.String() != "hola mundo" {
+			t.Errorf("Unexpected result: %v %v", resp.StatusCode, resp.String())
+		}
+	}
+
+	if hits != 2 || notModified != 1 {
+		t.Errorf("Expected 2 requests and 1 not modified, got %d and %d", hits, notModified)
+	}
+}
+
+// This tests stale responses are revalidated once in the background, however
+// many requests are served stale meanwhile
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) > 1 {
+			<-release
+		}
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		fmt.Fprint(w, "hola mundo")
+	}))
+	defer ts.Close()
+
+	cache := jaguar.NewMemoryCache(10)
+	j := jaguar.New()
+	if _, err := j.WithCache(cache).Get(ts.URL).Send(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j := jaguar.New()
+			resp, err := j.WithCache(cache).Get(ts.URL).Send()
+			if err != nil || resp.Header.Get(jaguar.CacheHeader) != "stale" {
+				t.Errorf("Expected stale response, got %v %v", resp.Header.Get(jaguar.CacheHeader), err)
+			}
+		}()
+	}
+	wg.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Errorf("Expected 1 background revalidation, got %d", n-1)
+	}
+}
+
+// This tests no-store responses are not cached and POST invalidates
+func TestCacheNoStoreAndInvalidate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "no-store")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	cache := jaguar.NewMemoryCache(10)
+	j := jaguar.New()
+	j.WithCache(cache).Get(ts.URL + "/private").Send()
+	if cache.Len() != 0 {
+		t.Errorf("Expected no-store response not to be cached")
+	}
+
+	j = jaguar.New()
+	j.WithCache(cache).Get(ts.URL + "/public").Send()
+	if cache.Len() != 1 {
+		t.Errorf("Expected response to be cached")
+	}
+
+	j = jaguar.New()
+	j.WithCache(cache).Post(ts.URL + "/public").Send()
+	if cache.Len() != 0 {
+		t.Errorf("Expected POST to invalidate cached response")
+	}
+}
+
+// This tests responses to requests with credentials are only stored when
+// public
+func TestCacheCredentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		fmt.Fprint(w, "profile of "+r.Header.Get("Authorization"))
+	}))
+	defer ts.Close()
+
+	cache := jaguar.NewMemoryCache(10)
+	for _, user := range []string{"alice", "bob"} {
+		j := jaguar.New()
+		resp, err := j.WithCache(cache).WithAuth(jaguar.BearerAuth(user)).Get(ts.URL + "/profile").Send()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if resp.String() != "profile of Bearer "+user {
+			t.Errorf("Unexpected result for %s: %v", user, resp.String())
+		}
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Expected private responses not to be cached")
+	}
+
+	j := jaguar.New()
+	j.WithCache(cache).WithAuth(jaguar.BearerAuth("alice")).Get(ts.URL + "/public").Send()
+	if cache.Len() != 1 {
+		t.Errorf("Expected public response to be cached")
+	}
+}
+
+// This tests the LRU evicts the least recently used entry
+func TestMemoryCacheEviction(t *testing.T) {
+	cache := jaguar.NewMemoryCache(2)
+	cache.Set("a", jaguar.CacheEntry{StatusCode: 200})
+	cache.Set("b", jaguar.CacheEntry{StatusCode: 200})
+	cache.Get("a")
+	cache.Set("c", jaguar.CacheEntry{StatusCode: 200})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("Expected b to be evicted")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Errorf("Expected a to be kept")
+	}
+}
+
+// gobCache mimics acache.Cache without needing memcached
+type gobCache map[string][]byte
+
+func (c gobCache) Get(key string, v interface{}) error {
+	b, ok := c[key]
+	if !ok {
+		return errors.New("cache miss")
+	}
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+func (c gobCache) Set(key string, data interface{}) error {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(data); err != nil {
+		return err
+	}
+	c[key] = b.Bytes()
+	return nil
+}
+
+func (c gobCache) Delete(key string) error {
+	delete(c, key)
+	return nil
+}
+
+// This tests the store backed by a gob key/value cache
+func TestCacherStore(t *testing.T) {
+	store := jaguar.NewCacherStore(gobCache{})
+	store.Set("k", jaguar.CacheEntry{StatusCode: 200, Body: []byte("hola"), Header: http.Header{"Etag": {`"x"`}}})
+
+	entry, ok := store.Get("k")
+	if !ok {
+		t.Fatalf("Expected stored entry")
+	}
+	if string(entry.Body) != "hola" || entry.Header.Get("ETag") != `"x"` {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+}
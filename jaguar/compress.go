@@ -0,0 +1,194 @@
+// compress.go - request and response compression for jaguar
+//
+// Responses encoded with gzip or deflate are decoded even when the
+// Accept-Encoding header was set by hand, which net/http only does when it
+// added the header itself. Decoded bodies are capped to defend against
+// decompression bombs. Request bodies can optionally be compressed.
+//
+// Brotli and zstd have no implementation in the standard library, bodies
+// using them are passed through untouched.
+
+package jaguar
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxDecompressedSize caps decoded response bodies unless
+// MaxDecompressedSize is set
+const DefaultMaxDecompressedSize = 64 << 20
+
+var ErrDecompressedTooLarge = errors.New("jaguar: decompressed response body too large")
+
+// Compress sets the encoding used to compress request bodies, "gzip" or
+// "deflate", an empty string sends bodies as is
+func (j *Jaguar) Compress(encoding string) *Jaguar {
+	j.Compression = encoding
+	return j
+}
+
+// compressTransport compresses request bodies and decodes responses
+type compressTransport struct {
+	encoding  string
+	maxSize   int64
+	transport http.RoundTripper
+}
+
+func (t *compressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.encoding != "" && req.Body != nil && req.Header.Get("Content-Encoding") == "" {
+		var err error
+		if req, err = compressRequest(req, t.encoding); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = decompressResponse(resp, t.maxSize); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func compressRequest(req *http.Request, encoding string) (*http.Request, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&b)
+	case "deflate":
+		w = zlib.NewWriter(&b)
+	default:
+		return nil, fmt.Errorf("jaguar: unsupported request compression %q", encoding)
+	}
+	if _, err = w.Write(body); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	compressed := b.Bytes()
+	req = req.Clone(req.Context())
+	req.Header.Set("Content-Encoding", encoding)
+	req.ContentLength = int64(len(compressed))
+	req.Body = ioutil.NopCloser(bytes.NewReader(compressed))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	return req, nil
+}
+
+// decompressResponse replaces the body with a decoding reader for each
+// gzip or deflate content coding, applied in reverse order
+func decompressResponse(resp *http.Response, maxSize int64) error {
+	codings := headerTokens(resp.Header, "Content-Encoding")
+	if len(codings) == 0 {
+		return nil
+	}
+
+	body := resp.Body
+	reader := io.Reader(body)
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch strings.ToLower(codings[i]) {
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(reader)
+		case "deflate":
+			reader, err = newDeflateReader(reader)
+		case "identity":
+			continue
+		default:
+			// can't decode further, leave the rest encoded
+			if i == len(codings)-1 {
+				return nil
+			}
+			return fmt.Errorf("jaguar: unsupported content encoding %q", codings[i])
+		}
+		if err == io.EOF {
+			// empty body, as sent with 204 and HEAD responses
+			reader = bytes.NewReader(nil)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if maxSize == 0 {
+		maxSize = DefaultMaxDecompressedSize
+	}
+	if maxSize > 0 {
+		reader = &capReader{r: reader, remaining: maxSize}
+	}
+
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{reader, body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// newDeflateReader handles zlib wrapped deflate as specified, and the raw
+// deflate streams some servers send instead
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// capReader errors once more than remaining bytes have been read
+type capReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if c.remaining < 0 {
+		return 0, ErrDecompressedTooLarge
+	}
+	if int64(len(p)) > c.remaining+1 {
+		p = p[:c.remaining+1]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining < 0 {
+		return n + int(c.remaining), ErrDecompressedTooLarge
+	}
+	return n, err
+}
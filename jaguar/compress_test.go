@@ -0,0 +1,115 @@
+package jaguar_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/automattic/go/jaguar"
+)
+
+func encode(encoding, s string) []byte {
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&b)
+	case "deflate":
+		w = zlib.NewWriter(&b)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&b, flate.DefaultCompression)
+	}
+	io.WriteString(w, s)
+	w.Close()
+	return b.Bytes()
+}
+
+// This tests responses are decoded even with Accept-Encoding set manually
+func TestDecompressResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.URL.Query().Get("e")
+		header := encoding
+		if encoding == "raw-deflate" {
+			header = "deflate"
+		}
+		w.Header().Set("Content-Encoding", header)
+		w.Write(encode(encoding, "hola mundo"))
+	}))
+	defer ts.Close()
+
+	for _, encoding := range []string{"gzip", "deflate", "raw-deflate"} {
+		j := jaguar.New()
+		j.Header.Set("Accept-Encoding", "gzip, deflate")
+		j.Params.Set("e", encoding)
+		resp, err := j.Get(ts.URL).Send()
+		if err != nil {
+			t.Fatalf("%s: Error: %v", encoding, err)
+		}
+		if resp.String() != "hola mundo" {
+			t.Errorf("%s: Unexpected result: %q", encoding, resp.String())
+		}
+		if resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%s: Expected Content-Encoding to be removed", encoding)
+		}
+	}
+}
+
+// This tests decoded bodies over the cap return an error
+func TestDecompressLimit(t *testing.T) {
+	bomb := encode("gzip", strings.Repeat("0", 1<<20))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(bomb)
+	}))
+	defer ts.Close()
+
+	j := jaguar.New()
+	j.MaxDecompressedSize = 1024
+	_, err := j.Get(ts.URL).Send()
+	if !errors.Is(err, jaguar.ErrDecompressedTooLarge) {
+		t.Errorf("Expected size limit error, got: %v", err)
+	}
+
+	j = jaguar.New()
+	resp, err := j.Get(ts.URL).Send()
+	if err != nil || len(resp.Bytes) != 1<<20 {
+		t.Errorf("Expected full body under default limit: %d %v", len(resp.Bytes), err)
+	}
+}
+
+// This tests request bodies are compressed
+func TestCompressRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			body, _ = gzip.NewReader(r.Body)
+		case "deflate":
+			body, _ = zlib.NewReader(r.Body)
+		}
+		b, _ := ioutil.ReadAll(body)
+		fmt.Fprintf(w, "%s %s", r.Header.Get("Content-Encoding"), b)
+	}))
+	defer ts.Close()
+
+	for _, encoding := range []string{"gzip", "deflate"} {
+		j := jaguar.New()
+		j.JsonData = map[string]interface{}{"title": "hello"}
+		resp, err := j.Compress(encoding).Post(ts.URL).JsonRequest()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if expected := encoding + ` {"title":"hello"}`; resp.String() != expected {
+			t.Errorf("got: %q; expected: %q", resp.String(), expected)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+// dump.go - request dumping and curl export for debugging jaguar requests
+//
+// Dump returns the request as it would go on the wire, including the
+// multipart body and headers Send adds. Curl returns an equivalent curl
+// command with credentials redacted, and WithDebug logs each request and
+// response with bodies truncated.
+
+package jaguar
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redacted replaces secret values in curl commands and debug output
+const Redacted = "REDACTED"
+
+// DefaultDebugBodyLimit is the number of body bytes logged in debug mode
+const DefaultDebugBodyLimit = 1024
+
+// ErrCurlFileReaders is returned by Curl for requests with more than one
+// FileReader, curl can only read one of them from stdin
+var ErrCurlFileReaders = errors.New("jaguar: curl can't send more than one file reader")
+
+// Dump returns the wire format of the request Send would execute. Auth is
+// not run, authenticators may fetch tokens, the Authorization header is
+// shown redacted instead. Body and FileReaders are read into memory so the
+// request can still be sent.
+func (j *Jaguar) Dump() ([]byte, error) {
+	rewind, err := j.bufferBodies()
+	if err != nil {
+		return nil, err
+	}
+	defer rewind()
+
+	request, err := j.newRequest(j.sendsJson())
+	if err != nil {
+		return nil, err
+	}
+	if j.Auth != nil {
+		request.Header.Set("Authorization", Redacted)
+	}
+	return httputil.DumpRequestOut(request, true)
+}
+
+// bufferBodies replaces Body and FileReaders with in-memory copies, rewind
+// moves them back to the start once they have been read
+func (j *Jaguar) bufferBodies() (rewind func(), err error) {
+	var readers []*bytes.Reader
+	buffer := func(r io.Reader) (*bytes.Reader, error) {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		br := bytes.NewReader(b)
+		readers = append(readers, br)
+		return br, nil
+	}
+
+	if j.Body != nil {
+		r, err := buffer(j.Body)
+		if err != nil {
+			return nil, err
+		}
+		j.Body = r
+	}
+	for k, f := range j.FileReaders {
+		r, err := buffer(f.Reader)
+		if err != nil {
+			return nil, err
+		}
+		f.Reader = r
+		j.FileReaders[k] = f
+	}
+
+	return func() {
+		for _, r := range readers {
+			r.Seek(0, io.SeekStart)
+		}
+	}, nil
+}
+
+// Curl returns a curl command line equivalent to the request, with
+// credentials in headers, query, form parameters and JSON bodies redacted.
+// Body or a FileReader is read from stdin, so at most one FileReader can
+// be sent.
+func (j *Jaguar) Curl() (string, error) {
+	requestUrl, err := j.url()
+	if err != nil {
+		return "", err
+	}
+	if len(j.FileReaders) > 1 && j.Body == nil {
+		return "", ErrCurlFileReaders
+	}
+
+	noBody := j.RequestMethod == "GET" || j.RequestMethod == "HEAD"
+	asJson := j.sendsJson() && !noBody
+	if noBody || (!asJson && !j.hasFiles() && (j.RequestMethod == "OPTIONS" || j.Body != nil)) {
+		requestUrl = appendQuery(requestUrl, j.Params)
+	}
+
+	args := []string{"curl"}
+	if j.RequestMethod != "GET" {
+		args = append(args, "-X", j.RequestMethod)
+	}
+	if !j.VerifyCert {
+		args = append(args, "--insecure")
+	}
+	if j.Compression == "" {
+		args = append(args, "--compressed")
+	}
+	args = append(args, shellQuote(redactURL(requestUrl)))
+
+	header := cloneHeader(j.Header)
+	if j.Auth != nil {
+		// authenticators may sign the body, only the header name matters
+		header.Set("Authorization", Redacted)
+	}
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// curl builds these itself for multipart bodies
+		if j.hasFiles() && j.Body == nil && !noBody && k == "Content-Type" {
+			continue
+		}
+		for _, v := range header[k] {
+			args = append(args, "-H", shellQuote(k+": "+redactHeader(k, v)))
+		}
+	}
+
+	switch {
+	case noBody:
+	case j.Body != nil:
+		// the body may be binary or a stream, read it from stdin
+		args = append(args, "--data-binary", "@-")
+	case asJson:
+		b, err := json.Marshal(j.JsonData)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := header["Content-Type"]; !ok {
+			args = append(args, "-H", shellQuote("Content-Type: application/json"))
+		}
+		// redacts a decoded copy, JsonData is left as is
+		b = redactBody("application/json", b)
+		args = append(args, "--data-raw", shellQuote(string(b)))
+	case j.hasFiles():
+		// --form-string doesn't read values starting with @ or < as files
+		for _, k := range sortedKeys(j.Params) {
+			for _, v := range j.Params[k] {
+				args = append(args, "--form-string", shellQuote(k+"="+redactParam(k, v)))
+			}
+		}
+		for _, k := range sortedFiles(j.Files) {
+			args = append(args, "-F", shellQuote(k+"=@"+j.Files[k]+";filename="+filepath.Base(j.Files[k])))
+		}
+		// readers can't be named on the command line, read from stdin
+		for _, k := range sortedFileReaders(j.FileReaders) {
+			args = append(args, "-F", shellQuote(k+"=@-;filename="+filepath.Base(j.FileReaders[k].Filename)))
+		}
+	case j.RequestMethod != "OPTIONS" && len(j.Params) > 0:
+		args = append(args, "--data-raw", shellQuote(redactValues(j.Params).Encode()))
+	}
+
+	return strings.Join(args, " "), nil
+}
+
+// WithDebug logs each request and response to w, bodies are truncated to
+// DebugBodyLimit bytes and credentials are redacted from headers, urls and
+// form or JSON bodies
+func (j *Jaguar) WithDebug(w io.Writer) *Jaguar {
+	j.Debug = w
+	return j
+}
+
+// debugTransport dumps requests and responses to a writer
+type debugTransport struct {
+	w         io.Writer
+	limit     int
+	transport http.RoundTripper
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	redacted := req.Clone(req.Context())
+	redacted.Body = nil
+	redacted.ContentLength = 0
+	redacted.URL, _ = url.Parse(redactURL(req.URL.String()))
+	redactHeaders(redacted.Header)
+	head, err := httputil.DumpRequestOut(redacted, false)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := t.limit
+	if limit == 0 {
+		limit = DefaultDebugBodyLimit
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "---> %s %s\n", req.Method, redactURL(req.URL.String()))
+	b.Write(bytes.TrimRight(head, "\r\n"))
+	b.WriteString("\n")
+	writeBody(&b, redactBody(req.Header.Get("Content-Type"), body), limit)
+	t.w.Write(b.Bytes())
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		fmt.Fprintf(t.w, "<--- %s %s error: %v\n\n", req.Method, redactURL(req.URL.String()), err)
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	if err != nil {
+		return nil, err
+	}
+
+	b.Reset()
+	fmt.Fprintf(&b, "<--- %s %s\n", req.Method, redactURL(req.URL.String()))
+	fmt.Fprintf(&b, "%s %s\n", resp.Proto, resp.Status)
+	header := cloneHeader(resp.Header)
+	redactHeaders(header)
+	header.Write(&b)
+	writeBody(&b, redactBody(resp.Header.Get("Content-Type"), respBody), limit)
+	t.w.Write(b.Bytes())
+
+	return resp, nil
+}
+
+func writeBody(w *bytes.Buffer, body []byte, limit int) {
+	w.WriteString("\n")
+	if len(body) == 0 {
+		return
+	}
+
+	if bytes.IndexByte(body, 0) >= 0 || !utf8.Valid(body) {
+		fmt.Fprintf(w, "[%d bytes of binary data]\n\n", len(body))
+		return
+	}
+
+	if limit >= 0 && len(body) > limit {
+		w.Write(body[:limit])
+		fmt.Fprintf(w, "... (%d more bytes)", len(body)-limit)
+	} else {
+		w.Write(body)
+	}
+	w.WriteString("\n\n")
+}
+
+// sensitive reports whether a header or parameter name holds a secret
+func sensitive(name string) bool {
+	name = strings.ToLower(name)
+	switch name {
+	case "authorization", "proxy-authorization", "cookie", "set-cookie":
+		return true
+	}
+	for _, s := range []string{"token", "secret", "password", "passwd", "pwd", "signature", "api_key", "apikey", "api-key"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	// X-Auth style headers, but not author
+	return name == "auth" || strings.HasPrefix(name, "x-auth") || strings.HasSuffix(name, "-auth") || strings.HasSuffix(name, "_auth")
+}
+
+func redactHeader(name, value string) string {
+	if !sensitive(name) {
+		return value
+	}
+	// keep the scheme so the kind of auth is still visible
+	if scheme, _, ok := strings.Cut(value, " "); ok && strings.Contains(strings.ToLower(name), "authorization") {
+		return scheme + " " + Redacted
+	}
+	return Redacted
+}
+
+func redactHeaders(h http.Header) {
+	for k, values := range h {
+		for i, v := range values {
+			values[i] = redactHeader(k, v)
+		}
+		h[k] = values
+	}
+}
+
+func redactParam(name, value string) string {
+	if sensitive(name) {
+		return Redacted
+	}
+	return value
+}
+
+func redactValues(v url.Values) url.Values {
+	r := url.Values{}
+	for k, values := range v {
+		for _, value := range values {
+			r.Add(k, redactParam(k, value))
+		}
+	}
+	return r
+}
+
+// redactBody redacts sensitive fields of form and JSON bodies, such as a
+// password posted to a login form or the access_token of an OAuth response
+func redactBody(contentType string, body []byte) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return body
+		}
+		return []byte(redactValues(values).Encode())
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(body))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			return body
+		}
+		b, err := json.Marshal(redactJson(v))
+		if err != nil {
+			return body
+		}
+		return b
+	}
+	return body
+}
+
+func redactJson(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if sensitive(k) {
+				v[k] = Redacted
+			} else {
+				v[k] = redactJson(value)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactJson(v[i])
+		}
+	}
+	return v
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), Redacted)
+		}
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redactValues(u.Query()).Encode()
+	}
+	return u.String()
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+%", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func sortedKeys(v url.Values) []string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFiles(files map[string]string) []string {
+	keys := make([]string, 0, len(files))
+	for k := range files {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFileReaders(files map[string]FileReader) []string {
+	keys := make([]string, 0, len(files))
+	for k := range files {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,253 @@
+package jaguar_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/automattic/go/jaguar"
+)
+
+// This tests Dump includes the multipart body and headers Send adds
+func TestDump(t *testing.T) {
+	dir, err := os.MkdirTemp("", "jaguar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "upload.txt")
+	ioutil.WriteFile(path, []byte("file contents"), 0644)
+
+	j := jaguar.New()
+	j.Params.Add("foo", "bar")
+	j.Files["filedata"] = path
+	dump, err := j.Post("http://example.com/upload").Dump()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	for _, expected := range []string{
+		"POST /upload HTTP/1.1",
+		"Host: example.com",
+		"Content-Type: multipart/form-data; boundary=",
+		`Content-Disposition: form-data; name="foo"`,
+		`Content-Disposition: form-data; name="filedata"; filename="upload.txt"`,
+		"file contents",
+	} {
+		if !strings.Contains(string(dump), expected) {
+			t.Errorf("Expected dump to contain %q, got:\n%s", expected, dump)
+		}
+	}
+}
+
+// This tests Dump leaves the request unchanged, without running Auth, so
+// it can still be sent
+func TestDumpThenSend(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseMultipartForm(1 << 20)
+		b, _ := ioutil.ReadAll(r.Body)
+		if f, _, err := r.FormFile("upload"); err == nil {
+			b, _ = ioutil.ReadAll(f)
+		}
+		fmt.Fprintf(w, "%s %s", r.Header.Get("Authorization"), b)
+	}))
+	defer ts.Close()
+
+	authCalls := 0
+	auth := jaguar.AuthFunc(func(req *http.Request) error {
+		authCalls++
+		req.Header.Set("Authorization", "Bearer token")
+		return nil
+	})
+
+	for _, setup := range []func(j *jaguar.Jaguar){
+		func(j *jaguar.Jaguar) { j.WithBody(strings.NewReader("raw body"), 0) },
+		func(j *jaguar.Jaguar) { j.AddFileReader("upload", "upload.txt", strings.NewReader("raw body")) },
+	} {
+		authCalls = 0
+		j := jaguar.New()
+		setup(&j)
+		j.WithAuth(auth).Put(ts.URL)
+
+		dump, err := j.Dump()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if !strings.Contains(string(dump), "raw body") || !strings.Contains(string(dump), "Authorization: REDACTED") {
+			t.Errorf("Unexpected dump:\n%s", dump)
+		}
+		if authCalls != 0 || len(j.Header) != 0 {
+			t.Errorf("Expected Dump not to authenticate or change headers, got %d calls and %v", authCalls, j.Header)
+		}
+
+		resp, err := j.Send()
+		if err != nil || resp.String() != "Bearer token raw body" {
+			t.Errorf("Unexpected result after dump: %q %v", resp.String(), err)
+		}
+	}
+}
+
+// This tests the curl command line and its redaction
+func TestCurl(t *testing.T) {
+	tests := []struct {
+		setup    func(j *jaguar.Jaguar)
+		expected string
+	}{
+		{
+			func(j *jaguar.Jaguar) {
+				j.Params.Add("q", "golang")
+				j.Params.Add("access_token", "secret")
+				j.Get("https://example.com/search")
+			},
+			"curl --compressed 'https://example.com/search?access_token=REDACTED&q=golang'",
+		},
+		{
+			func(j *jaguar.Jaguar) {
+				j.Header.Set("X-Auth", "my-secret-token")
+				j.Params.Add("title", "it's")
+				j.Params.Add("password", "hunter2")
+				j.Post("https://example.com/items")
+			},
+			"curl -X POST --compressed https://example.com/items -H 'X-Auth: REDACTED' --data-raw 'password=REDACTED&title=it%27s'",
+		},
+		{
+			func(j *jaguar.Jaguar) {
+				j.JsonData = map[string]interface{}{"complete": true}
+				j.WithJson().WithAuth(jaguar.BearerAuth("token")).Patch("https://api.cloudup.com/1/items/1")
+			},
+			`curl -X PATCH --compressed https://api.cloudup.com/1/items/1 -H 'Authorization: REDACTED' -H 'Content-Type: application/json' --data-raw '{"complete":true}'`,
+		},
+		{
+			func(j *jaguar.Jaguar) {
+				j.Params.Add("key", "uploads/1.jpg")
+				j.Files["file"] = "/tmp/1.jpg"
+				j.Post("https://s3.amazonaws.com/bucket")
+			},
+			"curl -X POST --compressed https://s3.amazonaws.com/bucket --form-string key=uploads/1.jpg -F 'file=@/tmp/1.jpg;filename=1.jpg'",
+		},
+		{
+			func(j *jaguar.Jaguar) {
+				j.JsonData = map[string]interface{}{
+					"password": "hunter2",
+					"client":   map[string]interface{}{"client_secret": "abc", "name": "app"},
+				}
+				j.WithJson().Post("https://example.com/token")
+			},
+			`curl -X POST --compressed https://example.com/token -H 'Content-Type: application/json' --data-raw '{"client":{"client_secret":"REDACTED","name":"app"},"password":"REDACTED"}'`,
+		},
+		{
+			func(j *jaguar.Jaguar) {
+				j.Params.Add("note", "@/etc/passwd")
+				j.Params.Add("tag", "a")
+				j.Params.Add("tag", "<b")
+				j.FileReaders["file"] = jaguar.FileReader{Filename: "1.jpg", Reader: strings.NewReader("jpg")}
+				j.Post("https://example.com/upload")
+			},
+			"curl -X POST --compressed https://example.com/upload --form-string note=@/etc/passwd --form-string tag=a --form-string 'tag=<b' -F 'file=@-;filename=1.jpg'",
+		},
+	}
+
+	for _, test := range tests {
+		j := jaguar.New()
+		test.setup(&j)
+		curl, err := j.Curl()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if curl != test.expected {
+			t.Errorf("got:\n%s\nexpected:\n%s", curl, test.expected)
+		}
+	}
+
+	// JsonData is redacted in the command only
+	j := jaguar.New()
+	j.JsonData = map[string]interface{}{"token": "abc"}
+	j.WithJson().Post("https://example.com/token")
+	j.Curl()
+	if j.JsonData["token"] != "abc" {
+		t.Errorf("Expected JsonData to be left as is, got %v", j.JsonData)
+	}
+
+	// only one reader can come from stdin
+	j = jaguar.New()
+	j.FileReaders["a"] = jaguar.FileReader{Filename: "a.txt", Reader: strings.NewReader("a")}
+	j.FileReaders["b"] = jaguar.FileReader{Filename: "b.txt", Reader: strings.NewReader("b")}
+	j.Post("https://example.com/upload")
+	if _, err := j.Curl(); err != jaguar.ErrCurlFileReaders {
+		t.Errorf("Expected ErrCurlFileReaders, got %v", err)
+	}
+}
+
+// This tests debug mode logs redacted, truncated dumps
+func TestDebug(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		fmt.Fprint(w, strings.Repeat("x", 100))
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	j := jaguar.New()
+	j.DebugBodyLimit = 10
+	j.Params.Add("p", "hello")
+	j.WithDebug(&buf).WithAuth(jaguar.BearerAuth("secret")).Post(ts.URL).Send()
+
+	out := buf.String()
+	for _, expected := range []string{
+		"---> POST " + ts.URL,
+		"Authorization: Bearer REDACTED",
+		"p=hello",
+		"<--- POST " + ts.URL,
+		"200 OK",
+		"Set-Cookie: REDACTED",
+		"xxxxxxxxxx... (90 more bytes)",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected debug output to contain %q, got:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("Expected secret to be redacted:\n%s", out)
+	}
+}
+
+// This tests secrets in form and JSON bodies are redacted in debug mode
+func TestDebugBodies(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, `{"access_token":"tok123","expires_in":3600,"user":{"name":"gopher","password":"hunter2"}}`)
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	j := jaguar.New()
+	j.Params.Add("log", "admin")
+	j.Params.Add("pwd", "hunter2")
+	j.WithDebug(&buf).Post(ts.URL).Send()
+
+	j = jaguar.New()
+	j.JsonData = map[string]interface{}{"client_secret": "hunter2", "grant_type": "password"}
+	j.WithDebug(&buf).Post(ts.URL).JsonRequest()
+
+	out := buf.String()
+	for _, expected := range []string{
+		"log=admin&pwd=REDACTED",
+		`{"client_secret":"REDACTED","grant_type":"password"}`,
+		`"access_token":"REDACTED"`,
+		`"expires_in":3600`,
+		`"password":"REDACTED"`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected debug output to contain %q, got:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "hunter2") || strings.Contains(out, "tok123") {
+		t.Errorf("Expected secrets to be redacted:\n%s", out)
+	}
+}
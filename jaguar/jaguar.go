@@ -8,7 +8,7 @@ package jaguar
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -18,6 +18,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type Jaguar struct {
@@ -26,8 +28,75 @@ type Jaguar struct {
 	Params        url.Values
 	Header        http.Header
 	Files         map[string]string
+	FileReaders   map[string]FileReader
 	JsonData      map[string]interface{}
 	VerifyCert    bool
+	TLS           TLSOptions
+
+	// Json makes Send post JsonData as a JSON body like JsonRequest,
+	// otherwise Send only sends Params, files or Body
+	Json bool
+
+	// Body, when set, is sent as is instead of JsonData, files or form
+	// params, which are then added to the query string. It isn't closed.
+	// ContentLength is its size when it isn't a bytes or strings reader,
+	// zero if unknown.
+	Body          io.Reader
+	ContentLength int64
+
+	// Compression encodes request bodies with "gzip" or "deflate"
+	Compression string
+
+	// MaxDecompressedSize caps decoded gzip and deflate response bodies,
+	// zero uses DefaultMaxDecompressedSize and a negative value no limit
+	MaxDecompressedSize int64
+
+	// Cache, when set, stores cacheable responses and revalidates them
+	// with conditional requests, see NewMemoryCache and NewCacherStore
+	Cache CacheStore
+
+	// Auth, when set, adds credentials to each request
+	Auth Authenticator
+
+	// Redirect overrides how redirects are followed, see RedirectPolicy
+	Redirect *RedirectPolicy
+
+	// Limiter, when set, limits the request rate and concurrency per host
+	Limiter *RateLimiter
+
+	// Proxy and Dial control how connections are made, see proxy.go
+	Proxy *ProxyConfig
+	Dial  DialFunc
+
+	// Transport, when set, replaces the base http.Transport, for platforms
+	// such as App Engine urlfetch. TLS, Proxy and Dial are then ignored.
+	Transport http.RoundTripper
+
+	// Timeout limits the time for the whole request including reading the
+	// response body, zero means no timeout
+	Timeout time.Duration
+
+	// Debug, when set, receives a dump of each request and response with
+	// bodies truncated to DebugBodyLimit bytes, -1 for no limit
+	Debug          io.Writer
+	DebugBodyLimit int
+
+	// Hooks receive events as the request progresses, see trace.go
+	Hooks []Hook
+
+	// Context of the request, used for cancellation and trace propagation
+	Context context.Context
+
+	// Session, when set, resolves the url against the base url and
+	// shares its cookie jar, see Session.NewRequest
+	Session *Session
+}
+
+// FileReader is a file uploaded from memory or a stream instead of disk,
+// the reader is consumed when the request is built
+type FileReader struct {
+	Filename string
+	Reader   io.Reader
 }
 
 type Response struct {
@@ -48,6 +117,7 @@ func New() Jaguar {
 	j.Params = make(url.Values)
 	j.Header = make(http.Header)
 	j.Files = map[string]string{}
+	j.FileReaders = map[string]FileReader{}
 	j.VerifyCert = true
 	return j
 }
@@ -62,17 +132,91 @@ func (j *Jaguar) Method(method string) *Jaguar {
 	return j
 }
 
+// AddFileReader uploads the contents of r as a file named filename in the
+// field of a multipart form
+func (j *Jaguar) AddFileReader(field, filename string, r io.Reader) *Jaguar {
+	if j.FileReaders == nil {
+		j.FileReaders = map[string]FileReader{}
+	}
+	j.FileReaders[field] = FileReader{Filename: filename, Reader: r}
+	return j
+}
+
+// WithBody sends r as the request body, size is its length or zero when
+// unknown, as for uploads to presigned URLs
+func (j *Jaguar) WithBody(r io.Reader, size int64) *Jaguar {
+	j.Body = r
+	j.ContentLength = size
+	return j
+}
+
+// WithJson makes Send post JsonData as a JSON body
+func (j *Jaguar) WithJson() *Jaguar {
+	j.Json = true
+	return j
+}
+
 func (j *Jaguar) SkipVerify() *Jaguar {
 	j.VerifyCert = false
 	return j
 }
 
+// WithCache enables client-side HTTP caching using the given store
+func (j *Jaguar) WithCache(store CacheStore) *Jaguar {
+	j.Cache = store
+	return j
+}
+
+// WithAuth sets the authenticator used to sign requests
+func (j *Jaguar) WithAuth(auth Authenticator) *Jaguar {
+	j.Auth = auth
+	return j
+}
+
+// WithRedirect sets the redirect policy, use NoRedirects to get the
+// redirect response back
+func (j *Jaguar) WithRedirect(policy *RedirectPolicy) *Jaguar {
+	j.Redirect = policy
+	return j
+}
+
+// WithRateLimit sets the limiter shared by requests to the same hosts
+func (j *Jaguar) WithRateLimit(limiter *RateLimiter) *Jaguar {
+	j.Limiter = limiter
+	return j
+}
+
+// WithContext sets the context used to cancel the request and carry a
+// trace context
+func (j *Jaguar) WithContext(ctx context.Context) *Jaguar {
+	j.Context = ctx
+	return j
+}
+
+// WithTransport sets the round tripper requests are sent with
+func (j *Jaguar) WithTransport(rt http.RoundTripper) *Jaguar {
+	j.Transport = rt
+	return j
+}
+
+// WithTimeout sets the time allowed for the request
+func (j *Jaguar) WithTimeout(timeout time.Duration) *Jaguar {
+	j.Timeout = timeout
+	return j
+}
+
 func (j *Jaguar) Get(url string) *Jaguar {
 	j.RequestUrl = url
 	j.RequestMethod = "GET"
 	return j
 }
 
+func (j *Jaguar) Head(url string) *Jaguar {
+	j.RequestUrl = url
+	j.RequestMethod = "HEAD"
+	return j
+}
+
 func (j *Jaguar) Post(url string) *Jaguar {
 	j.RequestUrl = url
 	j.RequestMethod = "POST"
@@ -97,22 +241,128 @@ func (j *Jaguar) Delete(url string) *Jaguar {
 	return j
 }
 
-// Send the request
+// Send the request, GET and HEAD requests have Params in the query string
+// and no body, other methods send Body, files, JsonData when Json is set or
+// Params as a form
 func (j *Jaguar) Send() (resp Response, err error) {
+	request, err := j.Request()
+	if err != nil {
+		return
+	}
+
+	return j.do(request)
+}
+
+// Request builds the http.Request that Send would execute, including the
+// body and any Authorization added by Auth. Body and FileReaders are read
+// by the request, send it with Do.
+func (j *Jaguar) Request() (*http.Request, error) {
+	return j.build(j.sendsJson())
+}
+
+// Do executes a request built by Request, such as after dumping it
+func (j *Jaguar) Do(request *http.Request) (Response, error) {
+	return j.do(request)
+}
+
+// Stream sends the request like Send and returns the response with its
+// body unread, for downloads too large to hold in memory. The caller must
+// close the body, Debug still reads it into memory.
+func (j *Jaguar) Stream() (*http.Response, error) {
+	request, err := j.Request()
+	if err != nil {
+		return nil, err
+	}
+
+	base, closeIdle := j.baseTransport()
+	rs, err := j.client(base).Do(request)
+	if err != nil {
+		closeIdle()
+		return nil, err
+	}
+	rs.Body = &closeIdleBody{ReadCloser: rs.Body, closeIdle: closeIdle}
+	return rs, nil
+}
+
+// closeIdleBody closes the idle connections of the transport built for
+// the request once the body is closed
+type closeIdleBody struct {
+	io.ReadCloser
+	closeIdle func()
+}
+
+func (b *closeIdleBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.closeIdle()
+	return err
+}
+
+// sendsJson reports whether Send posts JsonData
+func (j *Jaguar) sendsJson() bool {
+	return j.Json && j.JsonData != nil && !j.hasFiles() && j.Body == nil
+}
+
+func (j *Jaguar) build(asJson bool) (request *http.Request, err error) {
+	request, err = j.newRequest(asJson)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.Auth != nil {
+		// authenticators fetching tokens use the request settings
+		ctx := context.WithValue(request.Context(), authRequestKey{}, j)
+		authed := request.WithContext(ctx)
+		if err = j.Auth.Authenticate(authed); err != nil {
+			return nil, err
+		}
+		request = authed.WithContext(request.Context())
+	}
+
+	return request, nil
+}
+
+// newRequest builds the request without credentials, its header is a copy
+// of j.Header so j can be sent again
+func (j *Jaguar) newRequest(asJson bool) (request *http.Request, err error) {
 
 	var requestBody io.Reader
+	header := cloneHeader(j.Header)
+	requestUrl, err := j.url()
+	if err != nil {
+		return
+	}
 
-	// check if multipart form, determined by j.FILES set
-	if len(j.Files) > 0 {
-		requestBody, err = j.createMultiPartBody()
+	// GET and HEAD never have a body, otherwise check if raw, json or
+	// multipart form, determined by j.FILES set
+	if j.RequestMethod == "GET" || j.RequestMethod == "HEAD" {
+		requestUrl = appendQuery(requestUrl, j.Params)
+		requestBody = nil
+	} else if j.Body != nil && !asJson {
+		requestUrl = appendQuery(requestUrl, j.Params)
+		requestBody = j.Body
+		if _, ok := j.Body.(io.Closer); ok {
+			// the caller owns the body and may send it again, don't close it
+			requestBody = ioutil.NopCloser(j.Body)
+		}
+	} else if asJson {
+		jsonStr, err := json.Marshal(j.JsonData)
+		if err != nil {
+			return nil, err
+		}
+		header.Set("Content-Type", "application/json")
+		requestBody = bytes.NewReader(jsonStr)
+	} else if j.hasFiles() {
+		var contentType string
+		requestBody, contentType, err = j.createMultiPartBody()
 		if err != nil {
 			return
 		}
-	} else if j.RequestMethod == "GET" {
-		j.RequestUrl = j.RequestUrl + "?" + j.Params.Encode()
+		header.Set("Content-Type", contentType)
+	} else if j.RequestMethod == "OPTIONS" {
+		requestUrl = appendQuery(requestUrl, j.Params)
 		requestBody = nil
 	} else if j.RequestMethod == "POST" || j.RequestMethod == "PATCH" || j.RequestMethod == "PUT" || j.RequestMethod == "DELETE" {
-		j.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		header.Set("Content-Type", "application/x-www-form-urlencoded")
 		formData := []byte(j.Params.Encode())
 		requestBody = bytes.NewReader(formData)
 	} else {
@@ -120,20 +370,113 @@ func (j *Jaguar) Send() (resp Response, err error) {
 		return
 	}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: !j.VerifyCert},
+	ctx := j.Context
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
 	// build request object
-	client := &http.Client{Transport: tr}
-	request, err := http.NewRequest(j.RequestMethod, j.RequestUrl, requestBody)
+	request, err = http.NewRequestWithContext(ctx, j.RequestMethod, requestUrl, requestBody)
 	if err != nil {
 		return
 	}
 
-	request.Header = j.Header
+	request.Header = header
+	if j.Body != nil && j.ContentLength > 0 {
+		request.ContentLength = j.ContentLength
+	}
+
+	return request, nil
+}
+
+// baseTransport returns j.Transport, or a transport built from the TLS,
+// proxy and dial options with a func closing its idle connections
+func (j *Jaguar) baseTransport() (http.RoundTripper, func()) {
+	if j.Transport != nil {
+		return j.Transport, func() {}
+	}
+
+	tr := &http.Transport{
+		TLSClientConfig: j.tlsConfig(),
+	}
+	if j.Proxy != nil {
+		tr.Proxy = j.Proxy.proxyFunc()
+	}
+	if j.Dial != nil {
+		tr.DialContext = j.Dial
+	}
+	unix := &unixTransport{base: tr}
+	tr.RegisterProtocol("unix", unix)
+	return tr, func() {
+		tr.CloseIdleConnections()
+		unix.CloseIdleConnections()
+	}
+}
+
+// transport builds the round tripper used to execute requests, layering
+// compression, the optional debug log, rate limiter, cache and tracing on
+// top of the base transport
+func (j *Jaguar) transport(base http.RoundTripper) http.RoundTripper {
+	var rt http.RoundTripper = &compressTransport{encoding: j.Compression, maxSize: j.MaxDecompressedSize, transport: base}
+
+	if j.Debug != nil {
+		rt = &debugTransport{w: j.Debug, limit: j.DebugBodyLimit, transport: rt}
+	}
+
+	if j.Limiter != nil {
+		rt = &rateLimitTransport{limiter: j.Limiter, transport: rt}
+	}
+
+	if j.Cache != nil {
+		rt = &CacheTransport{Store: j.Cache, Transport: rt}
+	}
+
+	traced := false
+	if j.Context != nil {
+		_, traced = TraceFromContext(j.Context)
+	}
+	if len(j.Hooks) > 0 || traced {
+		rt = &traceTransport{hooks: j.Hooks, transport: rt}
+	}
+
+	return rt
+}
+
+// url returns the request url, resolved against the session base url
+func (j *Jaguar) url() (string, error) {
+	if j.Session != nil {
+		return j.Session.resolve(j.RequestUrl)
+	}
+	return j.RequestUrl, nil
+}
+
+// client builds the http client with the session cookie jar and
+// redirect policy
+func (j *Jaguar) client(base http.RoundTripper) *http.Client {
+	client := &http.Client{Transport: j.transport(base), Timeout: j.Timeout}
+
+	redirect := j.Redirect
+	if j.Session != nil {
+		client.Jar = j.Session.Jar
+		if redirect == nil {
+			redirect = j.Session.Redirect
+		}
+	}
+	if redirect != nil {
+		client.CheckRedirect = redirect.checkRedirect
+	}
+
+	return client
+}
 
-	// execute request
+// execute request and read the response
+func (j *Jaguar) do(request *http.Request) (resp Response, err error) {
+	// a transport built for this request isn't reused, close its
+	// keep-alive connections once the body is read
+	base, closeIdle := j.baseTransport()
+	defer closeIdle()
+
+	client := j.client(base)
 	rs, err := client.Do(request)
 	if err != nil {
 		return
@@ -144,13 +487,29 @@ func (j *Jaguar) Send() (resp Response, err error) {
 
 	resp.StatusCode = rs.StatusCode
 	resp.Header = rs.Header
-	resp.Bytes, _ = ioutil.ReadAll(rs.Body)
+	resp.Bytes, err = ioutil.ReadAll(rs.Body)
+
+	return resp, err
+}
 
-	return resp, nil
+// hasFiles reports whether the request is a multipart file upload
+func (j *Jaguar) hasFiles() bool {
+	return len(j.Files) > 0 || len(j.FileReaders) > 0
+}
+
+// appendQuery adds params to the query string of url, if there are any
+func appendQuery(url string, params url.Values) string {
+	if len(params) == 0 {
+		return url
+	}
+	if strings.Contains(url, "?") {
+		return url + "&" + params.Encode()
+	}
+	return url + "?" + params.Encode()
 }
 
 // create body for post - includes files, params
-func (j *Jaguar) createMultiPartBody() (body io.Reader, err error) {
+func (j *Jaguar) createMultiPartBody() (body io.Reader, contentType string, err error) {
 
 	var b bytes.Buffer
 
@@ -158,69 +517,62 @@ func (j *Jaguar) createMultiPartBody() (body io.Reader, err error) {
 
 	// add parameters first if there are parameters
 	// Amazon doesn't like params after File
-	// TODO: support multiple values for single parameter
-	for k, _ := range j.Params {
-		_ = writer.WriteField(k, j.Params.Get(k))
+	for k, values := range j.Params {
+		for _, v := range values {
+			_ = writer.WriteField(k, v)
+		}
 	}
 
 	// add files if we are uploading a file
 	for k, v := range j.Files {
 		file, err := os.Open(v)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
 		part, err := writer.CreateFormFile(k, filepath.Base(v))
 		if err != nil {
-			return nil, err
+			file.Close()
+			return nil, "", err
 		}
 
 		_, err = io.Copy(part, file)
+		file.Close()
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+	}
+
+	for k, f := range j.FileReaders {
+		part, err := writer.CreateFormFile(k, filepath.Base(f.Filename))
+		if err != nil {
+			return nil, "", err
+		}
+
+		_, err = io.Copy(part, f.Reader)
+		if err != nil {
+			return nil, "", err
 		}
 	}
 
 	err = writer.Close()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	body = &b
 
 	// content type might be different due to file uploads
-	j.Header.Set("Content-Type", writer.FormDataContentType())
-
-	return body, nil
+	return body, writer.FormDataContentType(), nil
 }
 
+// JsonRequest sends JsonData as the request body, except for GET and HEAD
+// which send Params in the query string
 func (j Jaguar) JsonRequest() (resp Response, err error) {
-
-	jsonStr, err := json.Marshal(j.JsonData)
-	if err != nil {
-		return resp, err
-	}
-
-	request, err := http.NewRequest(j.RequestMethod, j.RequestUrl, bytes.NewBuffer(jsonStr))
-
-	// add additional content-type header for json
-	j.Header.Add("Content-Type", "application/json")
-
-	request.Header = j.Header
-
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: !j.VerifyCert},
-	}
-
-	client := &http.Client{Transport: tr}
-	rs, err := client.Do(request)
+	request, err := j.build(true)
 	if err != nil {
 		return resp, err
 	}
-	defer rs.Body.Close()
 
-	resp.StatusCode = rs.StatusCode
-	resp.Header = rs.Header
-	resp.Bytes, _ = ioutil.ReadAll(rs.Body)
-	return resp, nil
+	return j.do(request)
 }
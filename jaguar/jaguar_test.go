@@ -5,9 +5,14 @@ package jaguar_test
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/automattic/go/jaguar"
 )
@@ -30,6 +35,25 @@ func TestGetBasic(t *testing.T) {
 	}
 }
 
+// This tests Stream returns the response with the body unread
+func TestStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hola mundo")
+	}))
+	defer ts.Close()
+
+	j := jaguar.New()
+	resp, err := j.Url(ts.URL).Stream()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "hola mundo" {
+		t.Errorf("Unexpected result: %q %v", body, err)
+	}
+}
+
 // This tests GET request with passing in a parameter
 func TestGetParams(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -76,3 +100,152 @@ func TestPostParams(t *testing.T) {
 		t.Errorf("Unexpected result: %v", resp.String())
 	}
 }
+
+// This tests multipart uploads send every value of a parameter
+func TestPostMultiValueParams(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseMultipartForm(1 << 20)
+		fmt.Fprint(w, strings.Join(r.MultipartForm.Value["tag"], ","))
+	}))
+	defer ts.Close()
+
+	tmp, err := ioutil.TempFile("", "jaguar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+
+	j := jaguar.New()
+	j.Params.Add("tag", "a")
+	j.Params.Add("tag", "b")
+	j.Files["file"] = tmp.Name()
+	resp, err := j.Post(ts.URL).Send()
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	if resp.String() != "a,b" {
+		t.Errorf("Unexpected result: %v", resp.String())
+	}
+}
+
+// This tests uploading a file from a reader
+func TestPostFileReader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("Error reading file: %v", err)
+			return
+		}
+		b, _ := ioutil.ReadAll(file)
+		fmt.Fprint(w, header.Filename+" "+r.FormValue("p")+" "+string(b))
+	}))
+	defer ts.Close()
+
+	j := jaguar.New()
+	j.Params.Add("p", "hello")
+	j.AddFileReader("file", "/tmp/hola.txt", strings.NewReader("hola mundo"))
+	resp, err := j.Post(ts.URL).Send()
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	if resp.String() != "hola.txt hello hola mundo" {
+		t.Errorf("Unexpected result: %v", resp.String())
+	}
+}
+
+// This tests a raw body is sent with its length and params in the query
+func TestPutBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %d %s %s", r.URL.Query().Get("part"), r.ContentLength, r.Header.Get("Content-Type"), body)
+	}))
+	defer ts.Close()
+
+	j := jaguar.New()
+	j.Params.Add("part", "2")
+	j.Header.Set("Content-Type", "text/plain")
+	body := io.NewSectionReader(strings.NewReader("xxhola mundo"), 2, 10)
+	resp, err := j.WithBody(body, 10).Put(ts.URL).Send()
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	if resp.String() != "2 10 text/plain hola mundo" {
+		t.Errorf("Unexpected result: %v", resp.String())
+	}
+}
+
+type headerTransport struct{}
+
+func (headerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("X-Transport", "custom")
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// This tests a custom transport and timeout
+func TestTransportTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("sleep") != "" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		fmt.Fprint(w, r.Header.Get("X-Transport"))
+	}))
+	defer ts.Close()
+
+	j := jaguar.New()
+	resp, err := j.WithTransport(headerTransport{}).Get(ts.URL).Send()
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if resp.String() != "custom" {
+		t.Errorf("Unexpected result: %v", resp.String())
+	}
+
+	j = jaguar.New()
+	j.Params.Add("sleep", "1")
+	if _, err = j.WithTimeout(10 * time.Millisecond).Get(ts.URL).Send(); err == nil {
+		t.Errorf("Expected timeout error")
+	}
+}
+
+// This tests JsonData is only sent by Send with Json set, and never in a
+// GET body
+func TestSendJson(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.URL.RawQuery, r.Header.Get("Content-Type"), b)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		send     func(j *jaguar.Jaguar) (jaguar.Response, error)
+		expected string
+	}{
+		{func(j *jaguar.Jaguar) (jaguar.Response, error) { return j.Post(ts.URL).Send() },
+			"POST  application/x-www-form-urlencoded q=golang"},
+		{func(j *jaguar.Jaguar) (jaguar.Response, error) { return j.WithJson().Post(ts.URL).Send() },
+			`POST  application/json {"title":"hola"}`},
+		{func(j *jaguar.Jaguar) (jaguar.Response, error) { return j.Post(ts.URL).JsonRequest() },
+			`POST  application/json {"title":"hola"}`},
+		{func(j *jaguar.Jaguar) (jaguar.Response, error) { return j.WithJson().Get(ts.URL).Send() },
+			"GET q=golang  "},
+		{func(j *jaguar.Jaguar) (jaguar.Response, error) { return j.Get(ts.URL).JsonRequest() },
+			"GET q=golang  "},
+	}
+	for _, test := range tests {
+		j := jaguar.New()
+		j.Params.Add("q", "golang")
+		j.JsonData = map[string]interface{}{"title": "hola"}
+		resp, err := test.send(&j)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if resp.String() != test.expected {
+			t.Errorf("got: %q; expected: %q", resp.String(), test.expected)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// proxy.go - proxies, unix domain sockets and custom dialers for jaguar
+//
+// Requests can go through an HTTP proxy (using CONNECT for https urls) or a
+// SOCKS5 proxy, with credentials taken from the proxy url and hosts
+// excluded using NO_PROXY style rules. Local daemons listening on a unix
+// socket are reached with WithUnixSocket or unix:// urls, giving the socket
+// path and request path separated by a colon:
+//
+//	unix:///var/run/docker.sock:/v1.41/info
+
+package jaguar
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DialFunc dials a network connection, as used by http.Transport
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// ProxyConfig selects the proxy used for requests
+type ProxyConfig struct {
+	// URL of the proxy, http://, https:// or socks5://, with optional
+	// user:password credentials
+	URL string
+
+	// NoProxy lists hosts to connect to directly: host names matching
+	// themselves and their subdomains, a leading dot for subdomains only,
+	// IP addresses, CIDR ranges and "*" for everything, each with an
+	// optional :port
+	NoProxy []string
+
+	// FromEnvironment uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY when URL
+	// is empty
+	FromEnvironment bool
+}
+
+// WithProxy sends requests through the proxy at proxyURL, except for hosts
+// matching noProxy
+func (j *Jaguar) WithProxy(proxyURL string, noProxy ...string) *Jaguar {
+	j.Proxy = &ProxyConfig{URL: proxyURL, NoProxy: noProxy}
+	return j
+}
+
+// WithDialer sets the function used to open connections
+func (j *Jaguar) WithDialer(dial DialFunc) *Jaguar {
+	j.Dial = dial
+	return j
+}
+
+// WithUnixSocket connects to the unix domain socket at path for every
+// request, whatever the url host
+func (j *Jaguar) WithUnixSocket(path string) *Jaguar {
+	return j.WithDialer(unixDialer(path))
+}
+
+func unixDialer(path string) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+}
+
+// proxyFunc returns the function for http.Transport.Proxy
+func (p *ProxyConfig) proxyFunc() func(*http.Request) (*url.URL, error) {
+	if p.URL == "" {
+		if p.FromEnvironment {
+			return http.ProxyFromEnvironment
+		}
+		return nil
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if matchNoProxy(p.NoProxy, req.URL) {
+			return nil, nil
+		}
+		return url.Parse(p.URL)
+	}
+}
+
+// matchNoProxy reports whether u should bypass the proxy
+func matchNoProxy(rules []string, u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ip := net.ParseIP(host)
+
+	for _, rule := range rules {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if rule == "" {
+			continue
+		}
+		if rule == "*" {
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(rule); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		ruleHost, rulePort := rule, ""
+		if h, p, err := net.SplitHostPort(rule); err == nil {
+			ruleHost, rulePort = h, p
+		}
+		if rulePort != "" && rulePort != port {
+			continue
+		}
+		ruleHost = strings.Trim(ruleHost, "[]")
+
+		if ruleIP := net.ParseIP(ruleHost); ruleIP != nil {
+			if ip != nil && ruleIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		if strings.HasPrefix(ruleHost, ".") {
+			if strings.HasSuffix(host, ruleHost) {
+				return true
+			}
+			continue
+		}
+		if host == ruleHost || strings.HasSuffix(host, "."+ruleHost) {
+			return true
+		}
+	}
+	return false
+}
+
+var ErrUnixURL = errors.New("jaguar: unix url must be unix:///path/to.sock:/request/path")
+
+// unixTransport handles unix:// urls
+type unixTransport struct {
+	base *http.Transport
+
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func (t *unixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	socket, path, ok := strings.Cut(req.URL.Path, ":")
+	if !ok || req.URL.Host != "" || socket == "" {
+		return nil, ErrUnixURL
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	t.mu.Lock()
+	if t.transports == nil {
+		t.transports = map[string]*http.Transport{}
+	}
+	tr, ok := t.transports[socket]
+	if !ok {
+		tr = t.base.Clone()
+		tr.Proxy = nil
+		tr.DialContext = unixDialer(socket)
+		t.transports[socket] = tr
+	}
+	t.mu.Unlock()
+
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = "localhost"
+	req.URL.Path = path
+	req.URL.RawPath = ""
+	req.Host = "localhost"
+	return tr.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of every socket
+func (t *unixTransport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tr := range t.transports {
+		tr.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,263 @@
+package jaguar_test
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/automattic/go/jaguar"
+)
+
+// httpProxy is a stand-in forward proxy supporting plain requests and CONNECT
+type httpProxy struct {
+	requests int32
+	auth     string
+}
+
+func (p *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&p.requests, 1)
+	if p.auth != "" && r.Header.Get("Proxy-Authorization") != p.auth {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+
+	if r.Method == "CONNECT" {
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+		conn.Close()
+		target.Close()
+		return
+	}
+
+	r.RequestURI = ""
+	r.Header.Del("Proxy-Authorization")
+	resp, err := http.DefaultTransport.RoundTrip(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	w.Header().Set("X-Via-Proxy", "1")
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// socks5Proxy is a stand-in SOCKS5 server with username/password auth
+func socks5Proxy(t *testing.T, user, pass string) (addr string, connects *int32, stop func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connects = new(int32)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				buf := make([]byte, 256)
+
+				// greeting, pick username/password auth
+				io.ReadFull(conn, buf[:2])
+				io.ReadFull(conn, buf[:buf[1]])
+				conn.Write([]byte{5, 2})
+
+				io.ReadFull(conn, buf[:2])
+				u := make([]byte, buf[1])
+				io.ReadFull(conn, u)
+				io.ReadFull(conn, buf[:1])
+				p := make([]byte, buf[0])
+				io.ReadFull(conn, p)
+				if string(u) != user || string(p) != pass {
+					conn.Write([]byte{1, 1})
+					return
+				}
+				conn.Write([]byte{1, 0})
+
+				// connect request
+				io.ReadFull(conn, buf[:4])
+				var host string
+				switch buf[3] {
+				case 1:
+					io.ReadFull(conn, buf[:4])
+					host = net.IP(buf[:4]).String()
+				case 3:
+					io.ReadFull(conn, buf[:1])
+					name := make([]byte, buf[0])
+					io.ReadFull(conn, name)
+					host = string(name)
+				}
+				io.ReadFull(conn, buf[:2])
+				port := binary.BigEndian.Uint16(buf[:2])
+
+				target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				atomic.AddInt32(connects, 1)
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}(conn)
+		}
+	}()
+
+	return l.Addr().String(), connects, func() { l.Close() }
+}
+
+// This tests plain and CONNECT requests through a HTTP proxy with auth
+func TestHTTPProxy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hola mundo")
+	}))
+	defer ts.Close()
+	tls := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hola tls")
+	}))
+	defer tls.Close()
+
+	proxy := &httpProxy{auth: "Basic dXNlcjpwYXNz"}
+	ps := httptest.NewServer(proxy)
+	defer ps.Close()
+	proxyURL := "http://user:pass@" + ps.Listener.Addr().String()
+
+	j := jaguar.New()
+	resp, err := j.WithProxy(proxyURL).Get(ts.URL).Send()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.String() != "hola mundo" || resp.Header.Get("X-Via-Proxy") != "1" {
+		t.Errorf("Expected response via proxy: %v %v", resp.StatusCode, resp.String())
+	}
+
+	j = jaguar.New()
+	resp, err = j.SkipVerify().WithProxy(proxyURL).Get(tls.URL).Send()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.String() != "hola tls" {
+		t.Errorf("Unexpected result: %v", resp.String())
+	}
+
+	if proxy.requests != 2 {
+		t.Errorf("Expected 2 proxied requests, got %d", proxy.requests)
+	}
+
+	// no proxy rules bypass the proxy
+	j = jaguar.New()
+	resp, _ = j.WithProxy(proxyURL, "127.0.0.0/8").Get(ts.URL).Send()
+	if resp.Header.Get("X-Via-Proxy") != "" || proxy.requests != 2 {
+		t.Errorf("Expected request to bypass the proxy")
+	}
+}
+
+// This tests requests through a SOCKS5 proxy
+func TestSOCKS5Proxy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hola mundo")
+	}))
+	defer ts.Close()
+
+	addr, connects, stop := socks5Proxy(t, "user", "pass")
+	defer stop()
+
+	j := jaguar.New()
+	resp, err := j.WithProxy("socks5://user:pass@" + addr).Get(ts.URL).Send()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.String() != "hola mundo" || *connects != 1 {
+		t.Errorf("Unexpected result: %v, %d connects", resp.String(), *connects)
+	}
+
+	j = jaguar.New()
+	if _, err = j.WithProxy("socks5://user:wrong@" + addr).Get(ts.URL).Send(); err == nil {
+		t.Errorf("Expected authentication failure")
+	}
+}
+
+// This tests requests to a server listening on a unix socket
+func TestUnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "jaguar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "jaguar.sock")
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path+" "+r.FormValue("p"))
+	}))
+	ts.Listener = l
+	ts.Start()
+	defer ts.Close()
+
+	j := jaguar.New()
+	j.Params.Add("p", "hello")
+	resp, err := j.WithUnixSocket(socket).Get("http://docker/v1.41/info").Send()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.String() != "/v1.41/info hello" {
+		t.Errorf("Unexpected result: %v", resp.String())
+	}
+
+	j = jaguar.New()
+	j.Params.Add("p", "hello")
+	resp, err = j.Get("unix://" + socket + ":/v1.41/info").Send()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.String() != "/v1.41/info hello" {
+		t.Errorf("Unexpected result: %v", resp.String())
+	}
+}
+
+// This tests a custom dialer is used for connections
+func TestWithDialer(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	}))
+	defer ts.Close()
+
+	var dialed string
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = addr
+		var d net.Dialer
+		return d.DialContext(ctx, network, ts.Listener.Addr().String())
+	}
+
+	j := jaguar.New()
+	resp, err := j.WithDialer(dial).Get("http://api.example.com/").Send()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.String() != "api.example.com" || dialed != "api.example.com:80" {
+		t.Errorf("Unexpected result: %v dialed %v", resp.String(), dialed)
+	}
+}
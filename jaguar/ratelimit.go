@@ -0,0 +1,284 @@
+// ratelimit.go - client-side rate and concurrency limits per host
+//
+// A RateLimiter holds a token bucket and a concurrency limit for each host
+// it sees. Requests wait for a token and a free slot before they are sent,
+// and hosts answering with Retry-After or an exhausted X-RateLimit-Remaining
+// are paused until they are expected to accept requests again.
+
+package jaguar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/automattic/go/throttler"
+)
+
+var ErrRateLimited = errors.New("jaguar: request throttled")
+
+// RateLimiter limits requests per host, it is safe to share between
+// requests and goroutines
+type RateLimiter struct {
+	// Rate is the number of requests per second allowed for each host,
+	// zero means no rate limit
+	Rate float64
+
+	// Burst is the bucket size, the number of requests which can be sent
+	// at once after a quiet period, defaults to 1
+	Burst int
+
+	// MaxConcurrent limits in-flight requests per host, zero means no limit
+	MaxConcurrent int
+
+	// Throttler, when set, also counts requests per host using the
+	// throttler package semantics, so limits can be shared between
+	// processes. Throttled requests fail with ErrRateLimited.
+	Throttler throttler.Throttler
+	MaxTries  int64
+	Window    time.Duration
+	Ban       time.Duration
+
+	mu        sync.Mutex
+	hosts     map[string]*hostLimit
+	lastSweep time.Time
+}
+
+type hostLimit struct {
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+	slots        chan struct{}
+
+	// requests waiting or in flight, the host is kept while non zero
+	active int
+}
+
+// sweepInterval is how often hosts are checked for eviction
+const sweepInterval = time.Minute
+
+// NewRateLimiter creates a limiter allowing rate requests per second with
+// the given burst and at most maxConcurrent in-flight requests per host
+func NewRateLimiter(rate float64, burst, maxConcurrent int) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: burst, MaxConcurrent: maxConcurrent}
+}
+
+func (l *RateLimiter) host(host string) *hostLimit {
+	if l.hosts == nil {
+		l.hosts = map[string]*hostLimit{}
+	}
+	h, ok := l.hosts[host]
+	if !ok {
+		l.sweep(time.Now())
+		h = &hostLimit{tokens: float64(l.burst()), last: time.Now()}
+		if l.MaxConcurrent > 0 {
+			h.slots = make(chan struct{}, l.MaxConcurrent)
+		}
+		l.hosts[host] = h
+	}
+	return h
+}
+
+// sweep evicts idle hosts so the map doesn't grow with every host seen by
+// a long running process. A host is idle when nothing is waiting or in
+// flight, it isn't paused and its bucket has refilled, so it is the same as
+// a new one.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for name, h := range l.hosts {
+		if h.active > 0 || now.Before(h.blockedUntil) {
+			continue
+		}
+		if l.Rate > 0 && h.tokens+now.Sub(h.last).Seconds()*l.Rate < float64(l.burst()) {
+			continue
+		}
+		delete(l.hosts, name)
+	}
+}
+
+func (l *RateLimiter) burst() int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
+
+// Wait blocks until a request to host is allowed or ctx is done, the
+// returned release func must be called when the request has finished
+func (l *RateLimiter) Wait(ctx context.Context, host string) (release func(), err error) {
+	if l.Throttler != nil && throttler.IsThrottled(l.Throttler, "jaguar:"+host, l.MaxTries, l.Window, l.Ban) {
+		return nil, ErrRateLimited
+	}
+
+	l.mu.Lock()
+	h := l.host(host)
+	h.active++
+	l.mu.Unlock()
+
+	done := func() {
+		l.mu.Lock()
+		h.active--
+		l.mu.Unlock()
+	}
+
+	// take a concurrency slot first so queued requests don't burn tokens
+	if h.slots != nil {
+		select {
+		case h.slots <- struct{}{}:
+		case <-ctx.Done():
+			done()
+			return nil, ctx.Err()
+		}
+	}
+	release = func() {
+		if h.slots != nil {
+			<-h.slots
+		}
+		done()
+	}
+
+	for {
+		delay := l.reserve(h)
+		if delay <= 0 {
+			return release, nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			release()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token if one is available, otherwise it returns how
+// long to wait before trying again
+func (l *RateLimiter) reserve(h *hostLimit) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(h.blockedUntil) {
+		return h.blockedUntil.Sub(now)
+	}
+	if l.Rate <= 0 {
+		return 0
+	}
+
+	h.tokens += now.Sub(h.last).Seconds() * l.Rate
+	if max := float64(l.burst()); h.tokens > max {
+		h.tokens = max
+	}
+	h.last = now
+
+	if h.tokens >= 1 {
+		h.tokens--
+		return 0
+	}
+	return time.Duration((1 - h.tokens) / l.Rate * float64(time.Second))
+}
+
+// Observe pauses a host when the response asks us to back off, using
+// Retry-After on 429 and 503 responses or X-RateLimit-Remaining and
+// X-RateLimit-Reset
+func (l *RateLimiter) Observe(host string, resp *http.Response) {
+	var until time.Time
+	now := time.Now()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		until = retryAfter(resp.Header.Get("Retry-After"), now)
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset := rateLimitReset(resp.Header.Get("X-RateLimit-Reset"), now); reset.After(until) {
+			until = reset
+		}
+	}
+
+	if until.IsZero() {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := l.host(host)
+	if until.After(h.blockedUntil) {
+		h.blockedUntil = until
+	}
+}
+
+// retryAfter parses seconds or a HTTP date
+func retryAfter(v string, now time.Time) time.Time {
+	if v == "" {
+		return time.Time{}
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return now.Add(time.Duration(s) * time.Second)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// rateLimitReset parses a unix timestamp, or seconds from now for
+// small values as some APIs send
+func rateLimitReset(v string, now time.Time) time.Time {
+	s, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || s <= 0 {
+		return time.Time{}
+	}
+	if s < 1000000000 {
+		return now.Add(time.Duration(s) * time.Second)
+	}
+	return time.Unix(s, 0)
+}
+
+// rateLimitTransport waits on the limiter before each round trip
+type rateLimitTransport struct {
+	limiter   *RateLimiter
+	transport http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	release, err := t.limiter.Wait(req.Context(), host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	t.limiter.Observe(host, resp)
+
+	// hold the concurrency slot until the body is closed
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
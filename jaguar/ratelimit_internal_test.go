@@ -0,0 +1,39 @@
+package jaguar
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// This tests idle hosts are evicted and busy or paused hosts kept
+func TestRateLimiterSweep(t *testing.T) {
+	l := NewRateLimiter(10, 1, 0)
+	ctx := context.Background()
+
+	idle, _ := l.Wait(ctx, "idle.example.com")
+	idle()
+	busy, _ := l.Wait(ctx, "busy.example.com")
+	paused, _ := l.Wait(ctx, "paused.example.com")
+	paused()
+
+	l.mu.Lock()
+	for _, h := range l.hosts {
+		h.last = h.last.Add(-time.Second)
+	}
+	l.hosts["paused.example.com"].blockedUntil = time.Now().Add(time.Minute)
+	l.lastSweep = time.Now().Add(-sweepInterval)
+	l.mu.Unlock()
+
+	release, _ := l.Wait(ctx, "new.example.com")
+	release()
+	busy()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for host, want := range map[string]bool{"idle.example.com": false, "busy.example.com": true, "paused.example.com": true, "new.example.com": true} {
+		if _, ok := l.hosts[host]; ok != want {
+			t.Errorf("Expected %s kept %v", host, want)
+		}
+	}
+}
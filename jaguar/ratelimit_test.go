@@ -0,0 +1,123 @@
+package jaguar_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/automattic/go/jaguar"
+	"github.com/automattic/go/throttler"
+)
+
+// This tests requests are spaced out by the token bucket
+func TestRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	limiter := jaguar.NewRateLimiter(20, 1, 0)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		j := jaguar.New()
+		if _, err := j.WithRateLimit(limiter).Get(ts.URL).Send(); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+	}
+
+	// first request is free, the next four wait 50ms each
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("Expected requests to be rate limited, took %v", elapsed)
+	}
+}
+
+// This tests the number of in-flight requests per host is capped
+func TestConcurrencyLimit(t *testing.T) {
+	var inflight, peak int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inflight, -1)
+	}))
+	defer ts.Close()
+
+	limiter := jaguar.NewRateLimiter(0, 0, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j := jaguar.New()
+			j.WithRateLimit(limiter).Get(ts.URL).Send()
+		}()
+	}
+	wg.Wait()
+
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent requests, got %d", peak)
+	}
+}
+
+// This tests Retry-After pauses further requests to the host
+func TestRetryAfter(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	limiter := jaguar.NewRateLimiter(0, 0, 0)
+	j := jaguar.New()
+	resp, _ := j.WithRateLimit(limiter).Get(ts.URL).Send()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Unexpected status: %v", resp.StatusCode)
+	}
+
+	start := time.Now()
+	j = jaguar.New()
+	j.WithRateLimit(limiter).Get(ts.URL).Send()
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("Expected request to wait for Retry-After, took %v", elapsed)
+	}
+}
+
+// This tests limits shared through a throttler fail fast
+func TestThrottlerLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	limiter := &jaguar.RateLimiter{
+		Throttler: throttler.NewMemoryThrottler(),
+		MaxTries:  2,
+		Window:    time.Minute,
+		Ban:       time.Minute,
+	}
+
+	for i := 0; i < 3; i++ {
+		j := jaguar.New()
+		_, err := j.WithRateLimit(limiter).Get(ts.URL).Send()
+		if i < 2 && err != nil {
+			t.Errorf("Unexpected error on request %d: %v", i, err)
+		}
+		if i == 2 && err == nil {
+			t.Errorf("Expected request %d to be throttled", i)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// session.go - cookie and header persistence across jaguar requests
+//
+// A Session keeps a cookie jar, default headers and a base URL so a flow
+// like logging into wp-login.php and then calling admin-ajax.php works
+// without copying Set-Cookie headers around.
+
+package jaguar
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// RedirectPolicy controls how redirects are followed
+type RedirectPolicy struct {
+	// Max is the number of redirects to follow, zero follows up to 10 like
+	// net/http and a negative value does not follow redirects, returning
+	// the redirect response instead
+	Max int
+
+	// PreserveAuth keeps the Authorization header on redirects to the same
+	// host, it is always dropped when the host changes
+	PreserveAuth bool
+}
+
+// NoRedirects returns redirect responses rather than following them
+var NoRedirects = &RedirectPolicy{Max: -1}
+
+var ErrTooManyRedirects = errors.New("jaguar: stopped after too many redirects")
+
+func (p *RedirectPolicy) checkRedirect(req *http.Request, via []*http.Request) error {
+	if p.Max < 0 {
+		return http.ErrUseLastResponse
+	}
+
+	max := p.Max
+	if max == 0 {
+		max = 10
+	}
+	if len(via) >= max {
+		return ErrTooManyRedirects
+	}
+
+	if !p.PreserveAuth || req.URL.Host != via[0].URL.Host {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// Session shares cookies, headers, auth and redirect settings between
+// requests created with NewRequest
+type Session struct {
+	// BaseURL is used to resolve relative request urls
+	BaseURL string
+
+	// Header values are added to every request
+	Header http.Header
+
+	Jar      http.CookieJar
+	Auth     Authenticator
+	Redirect *RedirectPolicy
+
+	path string
+}
+
+// NewSession creates a session with an in-memory cookie jar
+func NewSession() *Session {
+	jar, _ := cookiejar.New(nil)
+	return &Session{
+		Header:   make(http.Header),
+		Jar:      &persistentJar{jar: jar, cookies: map[string]savedCookie{}},
+		Redirect: &RedirectPolicy{PreserveAuth: true},
+	}
+}
+
+// LoadSession creates a session whose cookies are saved to path by Save,
+// cookies from a previous Save are loaded if the file exists
+func LoadSession(path string) (*Session, error) {
+	s := NewSession()
+	s.path = path
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var saved []savedCookie
+	if err = json.Unmarshal(b, &saved); err != nil {
+		return nil, err
+	}
+
+	jar := s.Jar.(*persistentJar)
+	for _, sc := range saved {
+		u, err := url.Parse(sc.URL)
+		if err != nil {
+			continue
+		}
+		c := sc.Cookie
+		jar.SetCookies(u, []*http.Cookie{&c})
+	}
+
+	return s, nil
+}
+
+// Save writes the session cookies to the file given to LoadSession
+func (s *Session) Save() error {
+	if s.path == "" {
+		return errors.New("jaguar: session was not loaded from a file")
+	}
+
+	jar, ok := s.Jar.(*persistentJar)
+	if !ok {
+		return errors.New("jaguar: session cookie jar can not be saved")
+	}
+
+	b, err := json.MarshalIndent(jar.saved(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, b, 0600)
+}
+
+// NewRequest creates a request which uses the session
+func (s *Session) NewRequest() *Jaguar {
+	j := New()
+	for k, v := range s.Header {
+		j.Header[k] = append([]string(nil), v...)
+	}
+	j.Session = s
+	j.Auth = s.Auth
+	return &j
+}
+
+func (s *Session) Get(url string) *Jaguar {
+	return s.NewRequest().Get(url)
+}
+
+func (s *Session) Post(url string) *Jaguar {
+	return s.NewRequest().Post(url)
+}
+
+// resolve a request url against the base url
+func (s *Session) resolve(ref string) (string, error) {
+	if s.BaseURL == "" {
+		return ref, nil
+	}
+	base, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(u).String(), nil
+}
+
+// Cookies returns the session cookies which would be sent to u
+func (s *Session) Cookies(u string) []*http.Cookie {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil
+	}
+	return s.Jar.Cookies(parsed)
+}
+
+// persistentJar records cookies as they are set so they can be saved,
+// net/http/cookiejar has no way to list its contents
+type persistentJar struct {
+	jar *cookiejar.Jar
+
+	mu      sync.Mutex
+	cookies map[string]savedCookie
+}
+
+type savedCookie struct {
+	URL    string
+	Cookie http.Cookie
+}
+
+func (p *persistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	p.jar.SetCookies(u, cookies)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range cookies {
+		domain := c.Domain
+		if domain == "" {
+			domain = u.Hostname()
+		}
+		key := domain + ";" + c.Path + ";" + c.Name
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(time.Now())) {
+			delete(p.cookies, key)
+			continue
+		}
+		sc := savedCookie{URL: u.Scheme + "://" + u.Host + u.Path, Cookie: *c}
+		if c.MaxAge > 0 {
+			sc.Cookie.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+			sc.Cookie.MaxAge = 0
+		}
+		p.cookies[key] = sc
+	}
+}
+
+func (p *persistentJar) Cookies(u *url.URL) []*http.Cookie {
+	return p.jar.Cookies(u)
+}
+
+// saved returns the unexpired cookies
+func (p *persistentJar) saved() []savedCookie {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	saved := []savedCookie{}
+	for _, sc := range p.cookies {
+		if !sc.Cookie.Expires.IsZero() && sc.Cookie.Expires.Before(time.Now()) {
+			continue
+		}
+		saved = append(saved, sc)
+	}
+	return saved
+}
@@ -0,0 +1,154 @@
+package jaguar_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/automattic/go/jaguar"
+)
+
+// wpServer fakes wp-login.php setting a cookie required by admin-ajax.php
+func wpServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/wp-login.php", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("log") != "admin" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "wordpress_logged_in", Value: "admin", Path: "/", MaxAge: 3600})
+		http.Redirect(w, r, "/wp-admin/", http.StatusFound)
+	})
+	mux.HandleFunc("/wp-admin/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "dashboard")
+	})
+	mux.HandleFunc("/wp-admin/admin-ajax.php", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("wordpress_logged_in")
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, c.Value+" "+r.Header.Get("X-Requested-With"))
+	})
+	return httptest.NewServer(mux)
+}
+
+// This tests cookies, headers and base url are shared between requests
+func TestSessionLogin(t *testing.T) {
+	ts := wpServer()
+	defer ts.Close()
+
+	s := jaguar.NewSession()
+	s.BaseURL = ts.URL
+	s.Header.Set("X-Requested-With", "XMLHttpRequest")
+
+	login := s.Post("/wp-login.php")
+	login.Params.Add("log", "admin")
+	resp, err := login.Send()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.String() != "dashboard" {
+		t.Errorf("Expected to follow redirect, got: %v", resp.String())
+	}
+
+	resp, err = s.Get("/wp-admin/admin-ajax.php").Send()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.String() != "admin XMLHttpRequest" {
+		t.Errorf("Unexpected result: %v", resp.String())
+	}
+}
+
+// This tests redirects can be returned instead of followed
+func TestNoRedirects(t *testing.T) {
+	ts := wpServer()
+	defer ts.Close()
+
+	j := jaguar.New()
+	j.Params.Add("log", "admin")
+	resp, err := j.WithRedirect(jaguar.NoRedirects).Post(ts.URL + "/wp-login.php").Send()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/wp-admin/" {
+		t.Errorf("Unexpected response: %v %v", resp.StatusCode, resp.Header)
+	}
+}
+
+// This tests the redirect limit and auth header handling
+func TestRedirectPolicy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/loop" {
+			http.Redirect(w, r, "/loop", http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, "/end", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer ts.Close()
+
+	j := jaguar.New()
+	_, err := j.WithRedirect(&jaguar.RedirectPolicy{Max: 3}).Get(ts.URL + "/loop").Send()
+	if err == nil {
+		t.Errorf("Expected too many redirects error")
+	}
+
+	j = jaguar.New()
+	j.WithAuth(jaguar.BearerAuth("token"))
+	resp, _ := j.WithRedirect(&jaguar.RedirectPolicy{PreserveAuth: true}).Get(ts.URL + "/start").Send()
+	if resp.String() != "Bearer token" {
+		t.Errorf("Expected auth preserved, got: %q", resp.String())
+	}
+
+	j = jaguar.New()
+	j.WithAuth(jaguar.BearerAuth("token"))
+	resp, _ = j.WithRedirect(&jaguar.RedirectPolicy{}).Get(ts.URL + "/start").Send()
+	if resp.String() != "" {
+		t.Errorf("Expected auth dropped, got: %q", resp.String())
+	}
+}
+
+// This tests cookies survive saving and loading a session
+func TestSessionSave(t *testing.T) {
+	ts := wpServer()
+	defer ts.Close()
+
+	dir, err := os.MkdirTemp("", "jaguar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cookies.json")
+
+	s, err := jaguar.LoadSession(path)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	s.BaseURL = ts.URL
+	login := s.Post("/wp-login.php")
+	login.Params.Add("log", "admin")
+	if _, err = login.Send(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = s.Save(); err != nil {
+		t.Fatalf("Error saving: %v", err)
+	}
+
+	s, err = jaguar.LoadSession(path)
+	if err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	s.BaseURL = ts.URL
+	resp, _ := s.Get("/wp-admin/admin-ajax.php").Send()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected loaded cookie to be sent, got status %v", resp.StatusCode)
+	}
+}
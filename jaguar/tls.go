@@ -0,0 +1,125 @@
+// tls.go - TLS options for jaguar
+//
+// Client certificates for mutual TLS, custom root CAs loaded from PEM
+// files, SPKI pinning and a minimum TLS version, so internal services can
+// be verified properly instead of turning verification off.
+
+package jaguar
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+var ErrCertificatePin = errors.New("jaguar: certificate does not match pinned public keys")
+
+// TLSOptions configure certificate verification and authentication
+type TLSOptions struct {
+	// Certificates are presented to servers requesting a client certificate
+	Certificates []tls.Certificate
+
+	// RootCAs replaces the system roots when set
+	RootCAs *x509.CertPool
+
+	// Pins are base64 SHA-256 hashes of certificate public keys, one of
+	// the certificates in the verified chain must match, or the server
+	// certificate when verification is skipped, see SPKIPin
+	Pins []string
+
+	// MinVersion is the minimum TLS version, such as tls.VersionTLS12
+	MinVersion uint16
+}
+
+// WithClientCert adds a client certificate for mutual TLS
+func (j *Jaguar) WithClientCert(cert tls.Certificate) *Jaguar {
+	j.TLS.Certificates = append(j.TLS.Certificates, cert)
+	return j
+}
+
+// WithRootCAs verifies servers against pool instead of the system roots
+func (j *Jaguar) WithRootCAs(pool *x509.CertPool) *Jaguar {
+	j.TLS.RootCAs = pool
+	return j
+}
+
+// PinSPKI only accepts servers whose verified chain includes one of the
+// public key pins
+func (j *Jaguar) PinSPKI(pins ...string) *Jaguar {
+	j.TLS.Pins = append(j.TLS.Pins, pins...)
+	return j
+}
+
+// MinTLSVersion sets the minimum TLS version, such as tls.VersionTLS12
+func (j *Jaguar) MinTLSVersion(version uint16) *Jaguar {
+	j.TLS.MinVersion = version
+	return j
+}
+
+// LoadClientCert loads a PEM encoded certificate and key pair
+func LoadClientCert(certFile, keyFile string) (tls.Certificate, error) {
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+// LoadCAPool creates a certificate pool from PEM bundles
+func LoadCAPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("jaguar: no certificates found in %s", file)
+		}
+	}
+	return pool, nil
+}
+
+// SPKIPin returns the base64 SHA-256 hash of the certificate public key,
+// the same format as HPKP and curl --pinnedpubkey sha256//
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// tlsConfig builds the tls.Config for the transport
+func (j *Jaguar) tlsConfig() *tls.Config {
+	config := &tls.Config{
+		InsecureSkipVerify: !j.VerifyCert,
+		Certificates:       j.TLS.Certificates,
+		RootCAs:            j.TLS.RootCAs,
+		MinVersion:         j.TLS.MinVersion,
+	}
+
+	if len(j.TLS.Pins) > 0 {
+		pins := map[string]bool{}
+		for _, pin := range j.TLS.Pins {
+			pins[pin] = true
+		}
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			// verified chains include the trusted root, so a CA key can be
+			// pinned. Certificates the server sends are not trusted as anyone
+			// can append a public certificate, without verification only the
+			// leaf proves the server holds the key.
+			chains := cs.VerifiedChains
+			if len(chains) == 0 && len(cs.PeerCertificates) > 0 {
+				chains = [][]*x509.Certificate{cs.PeerCertificates[:1]}
+			}
+			for _, chain := range chains {
+				for _, cert := range chain {
+					if pins[SPKIPin(cert)] {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("%w: %s", ErrCertificatePin, cs.ServerName)
+		}
+	}
+
+	return config
+}
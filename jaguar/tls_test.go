@@ -0,0 +1,219 @@
+package jaguar_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/automattic/go/jaguar"
+)
+
+// testCert is a generated certificate signed by parent, or self-signed
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, isCA bool, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+// newTLSServer starts a server with a certificate signed by ca, requiring
+// client certificates signed by ca when clientAuth is set
+func newTLSServer(ca, server *testCert, clientAuth bool) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+			return
+		}
+		fmt.Fprint(w, "anonymous")
+	}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{server.tlsCertificate()}}
+	if clientAuth {
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.cert)
+		ts.TLS.ClientCAs = pool
+		ts.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	ts.StartTLS()
+	return ts
+}
+
+// This tests a custom CA bundle and client certificate authentication
+func TestClientCertAndRootCAs(t *testing.T) {
+	ca := newTestCert(t, "Test CA", true, nil)
+	server := newTestCert(t, "server", false, ca)
+	client := newTestCert(t, "client", false, ca)
+
+	ts := newTLSServer(ca, server, true)
+	defer ts.Close()
+
+	dir, err := os.MkdirTemp("", "jaguar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.der}), 0644)
+
+	pool, err := jaguar.LoadCAPool(caFile)
+	if err != nil {
+		t.Fatalf("Error loading CA: %v", err)
+	}
+
+	// verification fails with system roots
+	j := jaguar.New()
+	if _, err = j.Get(ts.URL).Send(); err == nil {
+		t.Errorf("Expected unknown authority error")
+	}
+
+	// server requires a client certificate
+	j = jaguar.New()
+	if _, err = j.WithRootCAs(pool).Get(ts.URL).Send(); err == nil {
+		t.Errorf("Expected missing client certificate error")
+	}
+
+	j = jaguar.New()
+	resp, err := j.WithRootCAs(pool).WithClientCert(client.tlsCertificate()).Get(ts.URL).Send()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if resp.String() != "client" {
+		t.Errorf("Unexpected result: %v", resp.String())
+	}
+}
+
+// This tests public key pinning
+func TestPinSPKI(t *testing.T) {
+	ca := newTestCert(t, "Test CA", true, nil)
+	server := newTestCert(t, "server", false, ca)
+	other := newTestCert(t, "other", false, ca)
+
+	ts := newTLSServer(ca, server, false)
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	j := jaguar.New()
+	resp, err := j.WithRootCAs(pool).PinSPKI(jaguar.SPKIPin(server.cert)).Get(ts.URL).Send()
+	if err != nil || resp.String() != "anonymous" {
+		t.Errorf("Expected pinned request to succeed: %v %v", resp.String(), err)
+	}
+
+	// pinning the CA key accepts any certificate it signed
+	j = jaguar.New()
+	if _, err = j.WithRootCAs(pool).PinSPKI(jaguar.SPKIPin(ca.cert)).Get(ts.URL).Send(); err != nil {
+		t.Errorf("Expected CA pin to be accepted: %v", err)
+	}
+
+	j = jaguar.New()
+	_, err = j.WithRootCAs(pool).PinSPKI(jaguar.SPKIPin(other.cert)).Get(ts.URL).Send()
+	if !errors.Is(err, jaguar.ErrCertificatePin) {
+		t.Errorf("Expected pin mismatch error, got: %v", err)
+	}
+}
+
+// This tests a pinned certificate appended to another chain is not accepted
+func TestPinSPKIAppended(t *testing.T) {
+	ca := newTestCert(t, "Test CA", true, nil)
+	server := newTestCert(t, "server", false, ca)
+	attacker := newTestCert(t, "attacker", false, ca)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "owned")
+	}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{attacker.der, server.der},
+		PrivateKey:  attacker.key,
+	}}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	j := jaguar.New()
+	_, err := j.WithRootCAs(pool).PinSPKI(jaguar.SPKIPin(server.cert)).Get(ts.URL).Send()
+	if !errors.Is(err, jaguar.ErrCertificatePin) {
+		t.Errorf("Expected pin mismatch error, got: %v", err)
+	}
+
+	j = jaguar.New()
+	_, err = j.SkipVerify().PinSPKI(jaguar.SPKIPin(server.cert)).Get(ts.URL).Send()
+	if !errors.Is(err, jaguar.ErrCertificatePin) {
+		t.Errorf("Expected pin mismatch error without verification, got: %v", err)
+	}
+
+	// without verification the leaf can still be pinned
+	j = jaguar.New()
+	resp, err := j.SkipVerify().PinSPKI(jaguar.SPKIPin(attacker.cert)).Get(ts.URL).Send()
+	if err != nil || resp.String() != "owned" {
+		t.Errorf("Expected leaf pin to be accepted: %v %v", resp.String(), err)
+	}
+}
+
+// This tests the minimum TLS version is enforced
+func TestMinTLSVersion(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	ts.StartTLS()
+	defer ts.Close()
+
+	j := jaguar.New()
+	if _, err := j.SkipVerify().MinTLSVersion(tls.VersionTLS13).Get(ts.URL).Send(); err == nil {
+		t.Errorf("Expected protocol version error")
+	}
+
+	j = jaguar.New()
+	if _, err := j.SkipVerify().MinTLSVersion(tls.VersionTLS12).Get(ts.URL).Send(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+// trace.go - structured request events and trace propagation for jaguar
+//
+// Hooks receive an Event for each step of a request: DNS lookup, connect,
+// TLS handshake, first response byte and completion, built on
+// net/http/httptrace. A W3C trace context carried in the request context
+// is propagated in the traceparent header, and SlogHook logs events
+// through log/slog.
+
+package jaguar
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType names a step in the life of a request
+type EventType string
+
+const (
+	EventStart        EventType = "start"
+	EventDNSStart     EventType = "dns_start"
+	EventDNSDone      EventType = "dns_done"
+	EventConnectStart EventType = "connect_start"
+	EventConnectDone  EventType = "connect_done"
+	EventTLSStart     EventType = "tls_start"
+	EventTLSDone      EventType = "tls_done"
+	EventGotConn      EventType = "got_conn"
+	EventWroteRequest EventType = "wrote_request"
+	EventFirstByte    EventType = "first_byte"
+	EventDone         EventType = "done"
+)
+
+// Event describes a step of a request, Elapsed is measured from the start
+// of the request
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Elapsed time.Duration
+
+	Method string
+	URL    string
+
+	// Addr is the host looked up or the network address connected to
+	Addr string
+
+	// Reused is set on EventGotConn when a kept-alive connection is used
+	Reused bool
+
+	// StatusCode is set on EventDone when a response was received
+	StatusCode int
+
+	// TraceID and SpanID are set when the context carries a trace
+	TraceID string
+	SpanID  string
+
+	Err error
+}
+
+// Hook receives request events, it may be called from several goroutines
+type Hook interface {
+	Event(ctx context.Context, e Event)
+}
+
+// HookFunc adapts a function to the Hook interface
+type HookFunc func(ctx context.Context, e Event)
+
+func (f HookFunc) Event(ctx context.Context, e Event) {
+	f(ctx, e)
+}
+
+// WithHook adds a hook receiving events for the request
+func (j *Jaguar) WithHook(hook Hook) *Jaguar {
+	j.Hooks = append(j.Hooks, hook)
+	return j
+}
+
+// TraceContext is a W3C trace context
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	State   string
+}
+
+var ErrInvalidTraceParent = errors.New("jaguar: invalid traceparent")
+
+type traceContextKey struct{}
+
+// ContextWithTrace returns a context carrying the trace, requests made
+// with it send a traceparent header with a new child span id
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceFromContext returns the trace carried by ctx
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// TraceFromRequest reads the traceparent and tracestate headers of an
+// incoming request, to continue the trace in outgoing requests
+func TraceFromRequest(r *http.Request) (TraceContext, error) {
+	tc, err := ParseTraceParent(r.Header.Get("traceparent"))
+	if err != nil {
+		return tc, err
+	}
+	tc.State = r.Header.Get("tracestate")
+	return tc, nil
+}
+
+// ParseTraceParent parses a version 00 traceparent header
+func ParseTraceParent(s string) (tc TraceContext, err error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return tc, ErrInvalidTraceParent
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return tc, ErrInvalidTraceParent
+	}
+
+	var flags [1]byte
+	if _, err = hex.Decode(tc.TraceID[:], []byte(parts[1])); err != nil {
+		return tc, ErrInvalidTraceParent
+	}
+	if _, err = hex.Decode(tc.SpanID[:], []byte(parts[2])); err != nil {
+		return tc, ErrInvalidTraceParent
+	}
+	if _, err = hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return tc, ErrInvalidTraceParent
+	}
+	if tc.TraceID == [16]byte{} || tc.SpanID == [8]byte{} {
+		return tc, ErrInvalidTraceParent
+	}
+	tc.Flags = flags[0]
+	return tc, nil
+}
+
+// TraceParent formats the traceparent header value
+func (tc TraceContext) TraceParent() string {
+	return "00-" + hex.EncodeToString(tc.TraceID[:]) + "-" +
+		hex.EncodeToString(tc.SpanID[:]) + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+// child returns the trace context with a new random span id
+func (tc TraceContext) child() TraceContext {
+	rand.Read(tc.SpanID[:])
+	return tc
+}
+
+// traceTransport emits events to hooks and propagates trace context
+type traceTransport struct {
+	hooks     []Hook
+	transport http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	start := time.Now()
+	base := Event{Method: req.Method, URL: req.URL.String()}
+
+	if tc, ok := TraceFromContext(ctx); ok {
+		tc = tc.child()
+		req = req.Clone(ctx)
+		req.Header.Set("traceparent", tc.TraceParent())
+		if tc.State != "" {
+			req.Header.Set("tracestate", tc.State)
+		}
+		base.TraceID = hex.EncodeToString(tc.TraceID[:])
+		base.SpanID = hex.EncodeToString(tc.SpanID[:])
+	}
+
+	var mu sync.Mutex
+	emit := func(e Event) {
+		e.Method, e.URL, e.TraceID, e.SpanID = base.Method, base.URL, base.TraceID, base.SpanID
+		e.Time = time.Now()
+		e.Elapsed = e.Time.Sub(start)
+
+		// httptrace callbacks can overlap when dialing several addresses
+		mu.Lock()
+		defer mu.Unlock()
+		for _, h := range t.hooks {
+			h.Event(ctx, e)
+		}
+	}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			emit(Event{Type: EventDNSStart, Addr: info.Host})
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			emit(Event{Type: EventDNSDone, Err: info.Err})
+		},
+		ConnectStart: func(network, addr string) {
+			emit(Event{Type: EventConnectStart, Addr: addr})
+		},
+		ConnectDone: func(network, addr string, err error) {
+			emit(Event{Type: EventConnectDone, Addr: addr, Err: err})
+		},
+		TLSHandshakeStart: func() {
+			emit(Event{Type: EventTLSStart})
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			emit(Event{Type: EventTLSDone, Addr: state.ServerName, Err: err})
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			emit(Event{Type: EventGotConn, Addr: info.Conn.RemoteAddr().String(), Reused: info.Reused})
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			emit(Event{Type: EventWroteRequest, Err: info.Err})
+		},
+		GotFirstResponseByte: func() {
+			emit(Event{Type: EventFirstByte})
+		},
+	}
+
+	emit(Event{Type: EventStart})
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		emit(Event{Type: EventDone, Err: err})
+		return nil, err
+	}
+
+	// done once the body has been read and closed
+	status := resp.StatusCode
+	resp.Body = &doneBody{ReadCloser: resp.Body, done: func(err error) {
+		emit(Event{Type: EventDone, StatusCode: status, Err: err})
+	}}
+	return resp, nil
+}
+
+type doneBody struct {
+	io.ReadCloser
+	once sync.Once
+	err  error
+	done func(err error)
+}
+
+func (b *doneBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *doneBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.err) })
+	return err
+}
+
+// SlogHook logs events to logger, completed requests at info level or
+// error level when they failed, and the steps in between at debug level
+func SlogHook(logger *slog.Logger) Hook {
+	return HookFunc(func(ctx context.Context, e Event) {
+		attrs := []slog.Attr{
+			slog.String("event", string(e.Type)),
+			slog.String("method", e.Method),
+			slog.String("url", e.URL),
+			slog.Duration("elapsed", e.Elapsed),
+		}
+		if e.Addr != "" {
+			attrs = append(attrs, slog.String("addr", e.Addr))
+		}
+		if e.Type == EventGotConn {
+			attrs = append(attrs, slog.Bool("reused", e.Reused))
+		}
+		if e.StatusCode != 0 {
+			attrs = append(attrs, slog.Int("status", e.StatusCode))
+		}
+		if e.TraceID != "" {
+			attrs = append(attrs, slog.String("trace_id", e.TraceID), slog.String("span_id", e.SpanID))
+		}
+		if e.Err != nil {
+			attrs = append(attrs, slog.String("error", e.Err.Error()))
+		}
+
+		level := slog.LevelDebug
+		if e.Type == EventDone {
+			level = slog.LevelInfo
+		}
+		if e.Err != nil {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(ctx, level, "jaguar request", attrs...)
+	})
+}
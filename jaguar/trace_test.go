@@ -0,0 +1,122 @@
+package jaguar_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/automattic/go/jaguar"
+)
+
+// This tests hooks receive the request events in order
+func TestHookEvents(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hola mundo")
+	}))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var events []jaguar.EventType
+	hook := jaguar.HookFunc(func(ctx context.Context, e jaguar.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e.Type)
+		if e.Type == jaguar.EventDone && e.StatusCode != http.StatusOK {
+			t.Errorf("Unexpected status: %v", e.StatusCode)
+		}
+	})
+
+	j := jaguar.New()
+	if _, err := j.SkipVerify().WithHook(hook).Get(ts.URL).Send(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	expected := []jaguar.EventType{
+		jaguar.EventStart,
+		jaguar.EventConnectStart,
+		jaguar.EventConnectDone,
+		jaguar.EventTLSStart,
+		jaguar.EventTLSDone,
+		jaguar.EventGotConn,
+		jaguar.EventWroteRequest,
+		jaguar.EventFirstByte,
+		jaguar.EventDone,
+	}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("got events: %v; expected: %v", events, expected)
+	}
+}
+
+// This tests the traceparent header is propagated with a child span
+func TestTraceParent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("traceparent"))
+	}))
+	defer ts.Close()
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tc, err := jaguar.ParseTraceParent(parent)
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	if tc.TraceParent() != parent {
+		t.Errorf("got: %q; expected: %q", tc.TraceParent(), parent)
+	}
+
+	var spanID string
+	hook := jaguar.HookFunc(func(ctx context.Context, e jaguar.Event) {
+		spanID = e.SpanID
+	})
+
+	j := jaguar.New()
+	ctx := jaguar.ContextWithTrace(context.Background(), tc)
+	resp, err := j.WithContext(ctx).WithHook(hook).Get(ts.URL).Send()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	sent, err := jaguar.ParseTraceParent(resp.String())
+	if err != nil {
+		t.Fatalf("Invalid traceparent sent %q: %v", resp.String(), err)
+	}
+	if sent.TraceID != tc.TraceID || sent.SpanID == tc.SpanID || sent.Flags != tc.Flags {
+		t.Errorf("Expected child span of %q, got %q", parent, resp.String())
+	}
+	if !strings.Contains(resp.String(), spanID) {
+		t.Errorf("Expected event span id %q in %q", spanID, resp.String())
+	}
+
+	for _, invalid := range []string{"", "00-0000-00f067aa0ba902b7-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		if _, err := jaguar.ParseTraceParent(invalid); err == nil {
+			t.Errorf("Expected error parsing %q", invalid)
+		}
+	}
+}
+
+// This tests the slog adapter logs completed requests
+func TestSlogHook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	j := jaguar.New()
+	j.WithHook(jaguar.SlogHook(logger)).Get(ts.URL).Send()
+
+	out := buf.String()
+	if !strings.Contains(out, "event=done") || !strings.Contains(out, "status=418") {
+		t.Errorf("Unexpected log output: %q", out)
+	}
+	if strings.Contains(out, "event=connect_start") {
+		t.Errorf("Expected debug events to be filtered: %q", out)
+	}
+}
@@ -0,0 +1,86 @@
+package throttler
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryThrottler keeps counts in process memory, for use outside App Engine
+type MemoryThrottler struct {
+	mu        sync.Mutex
+	counts    map[string]*memoryCount
+	lastSweep time.Time
+}
+
+type memoryCount struct {
+	count  int64
+	expiry time.Time
+}
+
+// NewMemoryThrottler creates a throttler counting in memory, counts are
+// not shared between processes and are lost on restart
+func NewMemoryThrottler() *MemoryThrottler {
+	return &MemoryThrottler{counts: map[string]*memoryCount{}}
+}
+
+// sweep drops expired counts at most once a minute, keys which are never
+// looked up again would otherwise stay forever
+func (t *MemoryThrottler) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+
+	for key, c := range t.counts {
+		if now.After(c.expiry) {
+			delete(t.counts, key)
+		}
+	}
+}
+
+func (t *MemoryThrottler) get(key string) *memoryCount {
+	c, ok := t.counts[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(c.expiry) {
+		delete(t.counts, key)
+		return nil
+	}
+	return c
+}
+
+func (t *MemoryThrottler) GetCount(key string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c := t.get(key); c != nil {
+		return c.count
+	}
+	return 0
+}
+
+func (t *MemoryThrottler) AddCount(key string, expiry time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(time.Now())
+	t.counts[key] = &memoryCount{count: 1, expiry: time.Now().Add(expiry)}
+}
+
+func (t *MemoryThrottler) IncrementCount(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c := t.get(key); c != nil {
+		c.count++
+	}
+}
+
+func (t *MemoryThrottler) Ban(key string, maxTries int64, expiry time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(time.Now())
+	t.counts[key] = &memoryCount{count: maxTries, expiry: time.Now().Add(expiry)}
+}
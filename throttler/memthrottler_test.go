@@ -0,0 +1,61 @@
+package throttler_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/automattic/go/throttler"
+)
+
+// This tests requests are allowed up to maxTries in the window, then banned
+func TestMemoryThrottler(t *testing.T) {
+	tests := []struct {
+		name      string
+		requests  int
+		sleep     time.Duration
+		throttled bool
+	}{
+		{"first request", 1, 0, false},
+		{"up to max tries", 3, 0, false},
+		{"over max tries", 4, 0, true},
+		{"after the window", 4, 60 * time.Millisecond, false},
+	}
+	for _, test := range tests {
+		th := throttler.NewMemoryThrottler()
+		var throttled bool
+		for i := 0; i < test.requests; i++ {
+			if i == test.requests-1 {
+				time.Sleep(test.sleep)
+			}
+			throttled = throttler.IsThrottled(th, "key", 3, 50*time.Millisecond, time.Hour)
+		}
+		if throttled != test.throttled {
+			t.Errorf("%s: expected throttled %v", test.name, test.throttled)
+		}
+	}
+}
+
+// This tests a banned key stays throttled until the ban expires and keys
+// are counted separately
+func TestMemoryThrottlerBan(t *testing.T) {
+	th := throttler.NewMemoryThrottler()
+	for i := 0; i < 3; i++ {
+		throttler.IsThrottled(th, "a", 2, time.Hour, 50*time.Millisecond)
+	}
+
+	tests := []struct {
+		key   string
+		sleep time.Duration
+		count int64
+	}{
+		{"a", 0, 2},
+		{"b", 0, 0},
+		{"a", 60 * time.Millisecond, 0},
+	}
+	for _, test := range tests {
+		time.Sleep(test.sleep)
+		if count := th.GetCount(test.key); count != test.count {
+			t.Errorf("Expected count %d for %s after %v, got %d", test.count, test.key, test.sleep, count)
+		}
+	}
+}